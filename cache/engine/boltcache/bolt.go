@@ -0,0 +1,349 @@
+package boltcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// responsesBucket maps keys to the expiration time followed by the encoded entry of the response.
+	responsesBucket = []byte("responses")
+	// expiriesBucket indexes keys by the expiration time, so that expired responses are swept without scanning all of them.
+	expiriesBucket = []byte("expiries")
+)
+
+const (
+	// expiryLen is the size of the expiration time in big-endian Unix nanoseconds.
+	expiryLen = 8
+
+	defaultSweepInterval = time.Minute
+	openTimeout          = time.Second
+)
+
+// CacheEngine stores responses in a single bbolt database file.
+// Expired responses are removed by a background sweep, and the file can be shrunk by Compact.
+type CacheEngine struct {
+	path         string
+	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	now          func() time.Time
+
+	// mu guards db, which is replaced by Compact.
+	mu sync.RWMutex
+	db *bolt.DB
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = codecOption{}
+	_ Option = sweepIntervalOption{}
+	_ Option = compactionIntervalOption{}
+)
+
+type options struct {
+	keyGenerator       key.KeyGenerator
+	codec              entry.Codec
+	sweepInterval      time.Duration
+	compactionInterval time.Duration
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type codecOption struct {
+	codec entry.Codec
+}
+
+func (o codecOption) apply(opts *options) {
+	opts.codec = o.codec
+}
+
+// WithCodec specifies how responses are serialized (default entry.BinaryCodec).
+func WithCodec(codec entry.Codec) codecOption {
+	return codecOption{codec}
+}
+
+type sweepIntervalOption struct {
+	sweepInterval time.Duration
+}
+
+func (o sweepIntervalOption) apply(opts *options) {
+	opts.sweepInterval = o.sweepInterval
+}
+
+// WithSweepInterval sets the interval of removing expired responses in the background. Zero or less disables it.
+func WithSweepInterval(sweepInterval time.Duration) sweepIntervalOption {
+	return sweepIntervalOption{sweepInterval}
+}
+
+type compactionIntervalOption struct {
+	compactionInterval time.Duration
+}
+
+func (o compactionIntervalOption) apply(opts *options) {
+	opts.compactionInterval = o.compactionInterval
+}
+
+// WithCompactionInterval sets the interval of compacting the database file in the background. Zero or less disables it.
+func WithCompactionInterval(compactionInterval time.Duration) compactionIntervalOption {
+	return compactionIntervalOption{compactionInterval}
+}
+
+// New opens the database file, creating it if it does not exist, and starts the background sweep.
+// Close must be called to release the file.
+func New(path string, opts ...Option) (*CacheEngine, error) {
+	options := &options{
+		keyGenerator:       key.NewKeyGenerator(""),
+		codec:              entry.BinaryCodec{},
+		sweepInterval:      defaultSweepInterval,
+		compactionInterval: 0,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &CacheEngine{
+		path:         path,
+		keyGenerator: options.keyGenerator,
+		codec:        options.codec,
+		now:          time.Now,
+		db:           db,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go e.run(options.sweepInterval, options.compactionInterval)
+	return e, nil
+}
+
+func open(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(responsesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// run sweeps and compacts periodically until Close is called.
+func (e *CacheEngine) run(sweepInterval, compactionInterval time.Duration) {
+	defer close(e.done)
+	var sweep, compact <-chan time.Time
+	if sweepInterval > 0 {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+	if compactionInterval > 0 {
+		ticker := time.NewTicker(compactionInterval)
+		defer ticker.Stop()
+		compact = ticker.C
+	}
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-sweep:
+			_ = e.Sweep()
+		case <-compact:
+			_ = e.Compact()
+		}
+	}
+}
+
+// Close stops the background tasks and closes the database file. Only the first call takes effect.
+func (e *CacheEngine) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.closeErr = e.db.Close()
+	})
+	return e.closeErr
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	return e.keyGenerator.Key(req)
+}
+
+func (e *CacheEngine) Get(_ context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var resb []byte
+	err := e.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(responsesBucket).Get([]byte(key))
+		if len(v) < expiryLen || !e.now().Before(decodeExpiry(v)) {
+			return nil
+		}
+		// values are valid only during the transaction
+		resb = bytes.Clone(v[expiryLen:])
+		return nil
+	})
+	if err != nil || resb == nil {
+		return nil, false, err
+	}
+	res, err := entry.DecodeResponse(e.codec, resb, req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// Set stores the response. A response with a non-positive ttl is not stored.
+func (e *CacheEngine) Set(_ context.Context, key string, res *http.Response, ttl time.Duration) error {
+	resb, err := entry.EncodeResponse(e.codec, res)
+	if err != nil {
+		return err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.db.Update(func(tx *bolt.Tx) error {
+		if err := remove(tx, []byte(key)); err != nil || ttl <= 0 {
+			return err
+		}
+		expiry := encodeExpiry(e.now().Add(ttl))
+		if err := tx.Bucket(responsesBucket).Put([]byte(key), append(expiry, resb...)); err != nil {
+			return err
+		}
+		return tx.Bucket(expiriesBucket).Put(indexKey(expiry, []byte(key)), nil)
+	})
+}
+
+func (e *CacheEngine) Delete(_ context.Context, key string) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return remove(tx, []byte(key))
+	})
+}
+
+// Sweep removes expired responses.
+func (e *CacheEngine) Sweep() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	now := encodeExpiry(e.now())
+	return e.db.Update(func(tx *bolt.Tx) error {
+		responses, expiries := tx.Bucket(responsesBucket), tx.Bucket(expiriesBucket)
+		// deleting while iterating with a cursor skips entries, so collect them first
+		var expired [][]byte
+		c := expiries.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:expiryLen], now) <= 0; k, _ = c.Next() {
+			expired = append(expired, bytes.Clone(k))
+		}
+		for _, k := range expired {
+			if err := responses.Delete(k[expiryLen:]); err != nil {
+				return err
+			}
+			if err := expiries.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact rewrites the database file to release the space of removed responses, which bbolt does not return to the file system.
+// Requests to the engine wait until it completes. If it fails, the engine keeps using the database file as it is.
+func (e *CacheEngine) Compact() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tmpPath := e.path + ".compact"
+	dst, err := bolt.Open(tmpPath, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := bolt.Compact(dst, e.db, 0); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	// the compacted file is opened before it replaces the database file,
+	// since the file locked by the current handle cannot be opened again until the handle is closed
+	db, err := open(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, e.path); err != nil {
+		db.Close()
+		return err
+	}
+	old := e.db
+	e.db = db
+	return old.Close()
+}
+
+// remove deletes the response and its index entry.
+func remove(tx *bolt.Tx, key []byte) error {
+	responses := tx.Bucket(responsesBucket)
+	v := responses.Get(key)
+	if v == nil {
+		return nil
+	}
+	if len(v) >= expiryLen {
+		if err := tx.Bucket(expiriesBucket).Delete(indexKey(v[:expiryLen], key)); err != nil {
+			return err
+		}
+	}
+	return responses.Delete(key)
+}
+
+func encodeExpiry(t time.Time) []byte {
+	b := make([]byte, expiryLen)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeExpiry(v []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v[:expiryLen])))
+}
+
+func indexKey(expiry, key []byte) []byte {
+	return append(bytes.Clone(expiry), key...)
+}
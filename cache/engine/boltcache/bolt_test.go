@@ -0,0 +1,262 @@
+package boltcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func newEngine(t *testing.T, opts ...Option) *CacheEngine {
+	t.Helper()
+	e, err := New(filepath.Join(t.TempDir(), "cache.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func countEntries(t *testing.T, e *CacheEngine, bucket []byte) int {
+	t.Helper()
+	var n int
+	err := e.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	assert.NoError(t, err)
+	return n
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, entry.BinaryCodec{}, e.codec)
+		assert.FileExists(t, e.path)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := newEngine(t, WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+
+	t.Run("WithCodec", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t, WithCodec(entry.DumpCodec{}))
+		assert.Equal(t, entry.DumpCodec{}, e.codec)
+	})
+
+	t.Run("Responses persist across reopening", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		path := filepath.Join(t.TempDir(), "cache.db")
+		e1, err := New(path)
+		assert.NoError(t, err)
+		assert.NoError(t, e1.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e1.Close())
+
+		e2, err := New(path)
+		assert.NoError(t, err)
+		defer e2.Close()
+		_, ok, err := e2.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t, WithKeyGenerator(&testKeyGenerator{}))
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+
+	t.Run("overwriting replaces the index entry", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), 2*time.Hour))
+		assert.Equal(t, 1, countEntries(t, e, responsesBucket))
+		assert.Equal(t, 1, countEntries(t, e, expiriesBucket))
+	})
+
+	t.Run("expired responses are missed", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		e := newEngine(t)
+		e.now = func() time.Time { return now }
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Minute)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Minute)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCacheEngineSweep(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	now := time.Now()
+	e := newEngine(t, WithSweepInterval(0))
+	e.now = func() time.Time { return now }
+	for i := 0; i < 10; i++ {
+		err := e.Set(ctx, fmt.Sprintf("key%d", i), testutil.NewResponse(t, req, "OK\n"), time.Duration(i+1)*time.Minute)
+		assert.NoError(t, err)
+	}
+
+	now = now.Add(5 * time.Minute)
+	assert.NoError(t, e.Sweep())
+	assert.Equal(t, 5, countEntries(t, e, responsesBucket))
+	assert.Equal(t, 5, countEntries(t, e, expiriesBucket))
+	_, ok, err := e.Get(ctx, "key5", req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCacheEngineBackgroundSweep(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	e := newEngine(t, WithSweepInterval(10*time.Millisecond))
+	assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Millisecond))
+
+	assert.Eventually(t, func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return countEntries(t, e, responsesBucket) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheEngineCompact(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	e := newEngine(t)
+	body := strings.Repeat("0123456789", 1000)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, e.Set(ctx, fmt.Sprintf("key%d", i), testutil.NewResponse(t, req, body), time.Hour))
+	}
+	for i := 1; i < 100; i++ {
+		assert.NoError(t, e.Delete(ctx, fmt.Sprintf("key%d", i)))
+	}
+	before, err := os.Stat(e.path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, e.Compact())
+	after, err := os.Stat(e.path)
+	assert.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+	res, ok, err := e.Get(ctx, "key0", req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	resb, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(resb))
+
+	// responses set after compaction are written to the database file
+	assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+	assert.NoError(t, e.Close())
+	reopened, err := New(e.path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	_, ok, err = reopened.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestCacheEngineCompactFailure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	e := newEngine(t)
+	assert.NoError(t, e.Set(ctx, "key", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+	// the compacted file cannot be created
+	assert.NoError(t, os.Mkdir(e.path+".compact", 0o700))
+
+	assert.Error(t, e.Compact())
+	_, ok, err := e.Get(ctx, "key", req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, e.Set(ctx, "key2", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+}
+
+func TestCacheEngineClose(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t)
+	assert.NoError(t, e.Close())
+	assert.NoError(t, e.Close())
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t)
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, countEntries(t, e, expiriesBucket))
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
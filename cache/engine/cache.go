@@ -10,4 +10,12 @@ type CacheEngine interface {
 	Key(req *http.Request) (key string, err error)
 	Get(ctx context.Context, key string, req *http.Request) (res *http.Response, ok bool, err error)
 	Set(ctx context.Context, key string, res *http.Response, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Locker provides a lock on a key shared by processes, so that only one of them fetches the response for the key from the origin.
+type Locker interface {
+	// TryLock acquires the lock on the key for ttl without waiting.
+	// If acquired, it returns ok true and the function to release the lock.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(ctx context.Context) error, ok bool, err error)
 }
@@ -0,0 +1,326 @@
+package filecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+const (
+	// expiryLen is the size of the expiration time written at the head of each file.
+	expiryLen = 8
+	// tempPrefix is the name prefix of files being written.
+	tempPrefix = ".tmp-"
+)
+
+// CacheEngine stores responses as files under a directory.
+// Files are sharded into subdirectories by the hash of the key, and each file holds the expiration time followed by the encoded entry of the response.
+type CacheEngine struct {
+	dir          string
+	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	maxBytes     int64
+	now          func() time.Time
+
+	mu    sync.Mutex
+	files map[string]fileInfo
+	bytes int64
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+type fileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = codecOption{}
+	_ Option = maxBytesOption{}
+)
+
+type options struct {
+	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	maxBytes     int64
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type codecOption struct {
+	codec entry.Codec
+}
+
+func (o codecOption) apply(opts *options) {
+	opts.codec = o.codec
+}
+
+// WithCodec specifies how responses are serialized (default entry.BinaryCodec).
+func WithCodec(codec entry.Codec) codecOption {
+	return codecOption{codec}
+}
+
+type maxBytesOption struct {
+	maxBytes int64
+}
+
+func (o maxBytesOption) apply(opts *options) {
+	opts.maxBytes = o.maxBytes
+}
+
+// WithMaxBytes limits the total size of the files. Zero or less means no limit.
+// When the limit is exceeded, the oldest files are removed.
+// Only files written by this CacheEngine and those existing when it is created are taken into account.
+func WithMaxBytes(maxBytes int64) maxBytesOption {
+	return maxBytesOption{maxBytes}
+}
+
+// New creates the directory if it does not exist and returns a CacheEngine storing files in it.
+func New(dir string, opts ...Option) (*CacheEngine, error) {
+	options := &options{
+		keyGenerator: key.NewKeyGenerator(""),
+		codec:        entry.BinaryCodec{},
+		maxBytes:     0,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	e := &CacheEngine{
+		dir:          dir,
+		keyGenerator: options.keyGenerator,
+		codec:        options.codec,
+		maxBytes:     options.maxBytes,
+		now:          time.Now,
+		files:        map[string]fileInfo{},
+	}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// load indexes the files already stored in the directory.
+func (e *CacheEngine) load() error {
+	return filepath.WalkDir(e.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e.files[path] = fileInfo{size: info.Size(), modTime: info.ModTime()}
+		e.bytes += info.Size()
+		return nil
+	})
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	return e.keyGenerator.Key(req)
+}
+
+func (e *CacheEngine) Get(_ context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	path := e.path(key)
+	b, info, err := readFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(b) < expiryLen {
+		// a file broken by something other than this engine
+		return nil, false, e.removeIfSame(path, info)
+	}
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(b[:expiryLen])))
+	if !e.now().Before(expiresAt) {
+		return nil, false, e.removeIfSame(path, info)
+	}
+	res, err := entry.DecodeResponse(e.codec, b[expiryLen:], req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// Set writes the response to a temporary file and renames it, so that readers never see a partially written file.
+// A response with a non-positive ttl is not stored.
+func (e *CacheEngine) Set(_ context.Context, key string, res *http.Response, ttl time.Duration) error {
+	resb, err := entry.EncodeResponse(e.codec, res)
+	if err != nil {
+		return err
+	}
+	path := e.path(key)
+	if ttl <= 0 {
+		return e.remove(path)
+	}
+
+	b := make([]byte, expiryLen, expiryLen+len(resb))
+	binary.BigEndian.PutUint64(b, uint64(e.now().Add(ttl).UnixNano()))
+	b = append(b, resb...)
+	tmpPath, err := writeTemp(filepath.Dir(path), b)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// renamed under the lock, so that removeIfSame does not remove the file replaced in the meantime
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	e.forget(path)
+	e.files[path] = fileInfo{size: int64(len(b)), modTime: e.now()}
+	e.bytes += int64(len(b))
+	return e.evict()
+}
+
+func (e *CacheEngine) Delete(_ context.Context, key string) error {
+	return e.remove(e.path(key))
+}
+
+// path returns the file path for the key, sharded by the first byte of its hash.
+func (e *CacheEngine) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(e.dir, name[:2], name)
+}
+
+// readFile returns the content of the file with its information, which identifies the file read.
+func readFile(path string) ([]byte, fs.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, info, nil
+}
+
+// writeTemp writes b to a temporary file in dir, and returns its path.
+func writeTemp(dir string, b []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// evict removes the oldest files until the total size is within the limit.
+func (e *CacheEngine) evict() error {
+	if e.maxBytes <= 0 || e.bytes <= e.maxBytes {
+		return nil
+	}
+	paths := make([]string, 0, len(e.files))
+	for path := range e.files {
+		paths = append(paths, path)
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return e.files[a].modTime.Compare(e.files[b].modTime)
+	})
+	for _, path := range paths {
+		if e.bytes <= e.maxBytes {
+			break
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		e.forget(path)
+	}
+	return nil
+}
+
+func (e *CacheEngine) remove(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	e.forget(path)
+	return nil
+}
+
+// removeIfSame removes the file read by Get, unless it has been replaced by Set in the meantime.
+func (e *CacheEngine) removeIfSame(path string, read fs.FileInfo) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !os.SameFile(info, read) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	e.forget(path)
+	return nil
+}
+
+func (e *CacheEngine) forget(path string) {
+	if info, ok := e.files[path]; ok {
+		delete(e.files, path)
+		e.bytes -= info.size
+	}
+}
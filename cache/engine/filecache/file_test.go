@@ -0,0 +1,235 @@
+package filecache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func newEngine(t *testing.T, opts ...Option) *CacheEngine {
+	t.Helper()
+	e, err := New(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, entry.BinaryCodec{}, e.codec)
+		assert.Equal(t, int64(0), e.maxBytes)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := newEngine(t, WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+
+	t.Run("WithCodec", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t, WithCodec(entry.DumpCodec{}))
+		assert.Equal(t, entry.DumpCodec{}, e.codec)
+	})
+
+	t.Run("The directory is created", func(t *testing.T) {
+		t.Parallel()
+		dir := filepath.Join(t.TempDir(), "cache")
+		_, err := New(dir)
+		assert.NoError(t, err)
+		assert.DirExists(t, dir)
+	})
+
+	t.Run("Existing files are indexed", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		e1, err := New(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, e1.Set(context.Background(), "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+
+		e2, err := New(dir)
+		assert.NoError(t, err)
+		assert.Len(t, e2.files, 1)
+		assert.Equal(t, e1.bytes, e2.bytes)
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t, WithKeyGenerator(&testKeyGenerator{}))
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+
+	t.Run("files are sharded and no temporary files are left", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+
+		path := e.path("key1")
+		assert.FileExists(t, path)
+		assert.Equal(t, filepath.Base(path)[:2], filepath.Base(filepath.Dir(path)))
+		entries, err := os.ReadDir(filepath.Dir(path))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("expired files are missed and removed", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		e := newEngine(t)
+		e.now = func() time.Time { return now }
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Minute)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Minute)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.NoFileExists(t, e.path("key1"))
+		assert.Equal(t, int64(0), e.bytes)
+	})
+
+	t.Run("a file replaced after an expired one is read is not removed", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		e := newEngine(t)
+		e.now = func() time.Time { return now }
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Minute)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Minute)
+		var replaced bool
+		e.now = func() time.Time {
+			if !replaced {
+				// Set by another goroutine between reading the expired file and removing it
+				replaced = true
+				assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "fresh\n"), time.Hour))
+			}
+			return now
+		}
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "fresh\n", string(resb))
+		info, err := os.Stat(e.path("key1"))
+		assert.NoError(t, err)
+		assert.Equal(t, info.Size(), e.bytes)
+	})
+
+	t.Run("broken files are missed", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		path := e.path("key1")
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte("x"), 0o644))
+
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCacheEngineEviction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	now := time.Now()
+
+	probe := newEngine(t)
+	assert.NoError(t, probe.Set(ctx, "probe", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+	size := probe.bytes
+
+	e := newEngine(t, WithMaxBytes(size*2))
+	e.now = func() time.Time { return now }
+	for _, key := range []string{"key1", "key2", "key3"} {
+		now = now.Add(time.Second)
+		assert.NoError(t, e.Set(ctx, key, testutil.NewResponse(t, req, "OK\n"), time.Hour))
+	}
+
+	assert.Equal(t, size*2, e.bytes)
+	_, ok, _ := e.Get(ctx, "key1", req)
+	assert.False(t, ok)
+	assert.NoFileExists(t, e.path("key1"))
+	_, ok, _ = e.Get(ctx, "key2", req)
+	assert.True(t, ok)
+	_, ok, _ = e.Get(ctx, "key3", req)
+	assert.True(t, ok)
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t)
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), e.bytes)
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
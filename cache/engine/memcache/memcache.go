@@ -0,0 +1,292 @@
+package memcache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// defaultMaxItemSize leaves room for the key and the item header within the default 1MB item size limit of memcached.
+	defaultMaxItemSize = 1024*1024 - 1024
+	defaultMaxChunks   = 16
+
+	// flagChunked marks an item whose value refers to chunks stored in other items.
+	flagChunked = 1
+
+	// maxKeyLen leaves room for the suffix of chunk keys within the 250 bytes key length limit of memcached.
+	maxKeyLen = 200
+	// maxRelativeExpiration is the longest expiration memcached accepts as relative seconds.
+	// Longer ones are interpreted as Unix time.
+	maxRelativeExpiration = 30 * 24 * time.Hour
+)
+
+// ErrTooLarge is returned by Set when the response does not fit within the maximum number of chunks.
+var ErrTooLarge = errors.New("memcache: response is too large")
+
+type CacheEngine struct {
+	client       MemcacheClient
+	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	maxItemSize  int
+	maxChunks    int
+	now          func() time.Time
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+// MemcacheClient is the subset of *memcache.Client used by CacheEngine.
+type MemcacheClient interface {
+	Get(key string) (*memcache.Item, error)
+	GetMulti(keys []string) (map[string]*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Delete(key string) error
+}
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = codecOption{}
+	_ Option = maxItemSizeOption{}
+	_ Option = maxChunksOption{}
+)
+
+type options struct {
+	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	maxItemSize  int
+	maxChunks    int
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type codecOption struct {
+	codec entry.Codec
+}
+
+func (o codecOption) apply(opts *options) {
+	opts.codec = o.codec
+}
+
+// WithCodec specifies how responses are serialized (default entry.BinaryCodec).
+func WithCodec(codec entry.Codec) codecOption {
+	return codecOption{codec}
+}
+
+type maxItemSizeOption struct {
+	maxItemSize int
+}
+
+func (o maxItemSizeOption) apply(opts *options) {
+	opts.maxItemSize = o.maxItemSize
+}
+
+// WithMaxItemSize sets the maximum size of a value stored in an item.
+// It should be smaller than the item size limit of memcached (-I option) to leave room for the key and the item header.
+// Zero or less means the default, which fits within the default limit of 1MB.
+func WithMaxItemSize(maxItemSize int) maxItemSizeOption {
+	return maxItemSizeOption{maxItemSize}
+}
+
+type maxChunksOption struct {
+	maxChunks int
+}
+
+func (o maxChunksOption) apply(opts *options) {
+	opts.maxChunks = o.maxChunks
+}
+
+// WithMaxChunks sets the maximum number of items a response is split into.
+// Responses needing more items are rejected with ErrTooLarge. Set 1 to disable chunking.
+func WithMaxChunks(maxChunks int) maxChunksOption {
+	return maxChunksOption{maxChunks}
+}
+
+func New(client MemcacheClient, opts ...Option) *CacheEngine {
+	options := &options{
+		keyGenerator: key.NewKeyGenerator(""),
+		codec:        entry.BinaryCodec{},
+		maxItemSize:  defaultMaxItemSize,
+		maxChunks:    defaultMaxChunks,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+	if options.maxItemSize <= 0 {
+		options.maxItemSize = defaultMaxItemSize
+	}
+
+	return &CacheEngine{
+		client:       client,
+		keyGenerator: options.keyGenerator,
+		codec:        options.codec,
+		maxItemSize:  options.maxItemSize,
+		maxChunks:    options.maxChunks,
+		now:          time.Now,
+	}
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	return e.keyGenerator.Key(req)
+}
+
+func (e *CacheEngine) Get(_ context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	item, err := e.client.Get(itemKey(key))
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	resb := item.Value
+	if item.Flags&flagChunked != 0 {
+		var ok bool
+		resb, ok, err = e.getChunks(item)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+	}
+	res, err := entry.DecodeResponse(e.codec, resb, req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// getChunks joins the chunks referred by the item. If any of them has been evicted, it is regarded as a miss.
+func (e *CacheEngine) getChunks(item *memcache.Item) ([]byte, bool, error) {
+	generation, n, err := parseChunked(item.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(item.Key, generation, i)
+	}
+	items, err := e.client.GetMulti(keys)
+	if err != nil {
+		return nil, false, err
+	}
+	var resb []byte
+	for _, k := range keys {
+		chunk, ok := items[k]
+		if !ok {
+			return nil, false, nil
+		}
+		resb = append(resb, chunk.Value...)
+	}
+	return resb, true, nil
+}
+
+// Set stores the response in an item, or splits it into chunks if it exceeds the maximum item size.
+// Chunks are written under a new generation before the item referring to them, so that readers never see a mix of old and new chunks.
+// A response with a non-positive ttl is not stored.
+func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, ttl time.Duration) error {
+	resb, err := entry.EncodeResponse(e.codec, res)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return e.Delete(ctx, key)
+	}
+	k := itemKey(key)
+	expiration := e.expiration(ttl)
+	if len(resb) <= e.maxItemSize {
+		return e.client.Set(&memcache.Item{Key: k, Value: resb, Expiration: expiration})
+	}
+
+	n := (len(resb) + e.maxItemSize - 1) / e.maxItemSize
+	if n > e.maxChunks {
+		return ErrTooLarge
+	}
+	generation, err := newGeneration()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		chunk := resb[i*e.maxItemSize : min((i+1)*e.maxItemSize, len(resb))]
+		if err := e.client.Set(&memcache.Item{Key: chunkKey(k, generation, i), Value: chunk, Expiration: expiration}); err != nil {
+			return err
+		}
+	}
+	return e.client.Set(&memcache.Item{
+		Key:        k,
+		Value:      []byte(fmt.Sprintf("%s %d", generation, n)),
+		Flags:      flagChunked,
+		Expiration: expiration,
+	})
+}
+
+// Delete deletes the item. Its chunks, if any, are left to expire.
+func (e *CacheEngine) Delete(_ context.Context, key string) error {
+	if err := e.client.Delete(itemKey(key)); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+// expiration converts ttl to the expiration time of memcached, rounding up to seconds since 0 means no expiration.
+func (e *CacheEngine) expiration(ttl time.Duration) int32 {
+	seconds := (ttl + time.Second - 1) / time.Second
+	if ttl > maxRelativeExpiration {
+		return int32(e.now().Unix() + int64(seconds))
+	}
+	return int32(seconds)
+}
+
+// itemKey returns the key itself if memcached accepts it, otherwise its hash.
+func itemKey(key string) string {
+	if len(key) <= maxKeyLen && !strings.ContainsFunc(key, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func chunkKey(key, generation string, i int) string {
+	return key + "#" + generation + "#" + strconv.Itoa(i)
+}
+
+func newGeneration() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func parseChunked(value []byte) (generation string, n int, err error) {
+	generation, count, ok := strings.Cut(string(value), " ")
+	if ok {
+		n, err = strconv.Atoi(count)
+	}
+	if !ok || err != nil || n <= 0 {
+		return "", 0, fmt.Errorf("memcache: malformed chunked item: %q", value)
+	}
+	return generation, n, nil
+}
@@ -0,0 +1,302 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+// fakeServer is an in-process server speaking the subset of the memcached text protocol used by gomemcache.
+type fakeServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	items    map[string]*memcache.Item
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: l, items: map[string]*memcache.Item{}}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		s.mu.Lock()
+		switch fields[0] {
+		case "gets":
+			for _, k := range fields[1:] {
+				if item, ok := s.items[k]; ok {
+					fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n%s\r\n", k, item.Flags, len(item.Value), 0, item.Value)
+				}
+			}
+			fmt.Fprint(rw, "END\r\n")
+		case "set":
+			item := &memcache.Item{Key: fields[1]}
+			var size int
+			fmt.Sscanf(strings.Join(fields[2:], " "), "%d %d %d", &item.Flags, &item.Expiration, &size)
+			item.Value = make([]byte, size+2)
+			if _, err := io.ReadFull(rw, item.Value); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			item.Value = item.Value[:size]
+			s.items[item.Key] = item
+			fmt.Fprint(rw, "STORED\r\n")
+		case "delete":
+			if _, ok := s.items[fields[1]]; ok {
+				delete(s.items, fields[1])
+				fmt.Fprint(rw, "DELETED\r\n")
+			} else {
+				fmt.Fprint(rw, "NOT_FOUND\r\n")
+			}
+		default:
+			fmt.Fprint(rw, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) client() *memcache.Client {
+	return memcache.New(s.listener.Addr().String())
+}
+
+func (s *fakeServer) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+func (s *fakeServer) item(key string) (*memcache.Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	return item, ok
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := New(memcache.New())
+		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, entry.BinaryCodec{}, e.codec)
+		assert.Equal(t, defaultMaxItemSize, e.maxItemSize)
+		assert.Equal(t, defaultMaxChunks, e.maxChunks)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := New(memcache.New(), WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+
+	t.Run("WithCodec", func(t *testing.T) {
+		t.Parallel()
+		e := New(memcache.New(), WithCodec(entry.DumpCodec{}))
+		assert.Equal(t, entry.DumpCodec{}, e.codec)
+	})
+
+	t.Run("WithMaxItemSize and WithMaxChunks", func(t *testing.T) {
+		t.Parallel()
+		e := New(memcache.New(), WithMaxItemSize(100), WithMaxChunks(2))
+		assert.Equal(t, 100, e.maxItemSize)
+		assert.Equal(t, 2, e.maxChunks)
+	})
+
+	t.Run("WithMaxItemSize of zero or less means the default", func(t *testing.T) {
+		t.Parallel()
+		for _, size := range []int{0, -1} {
+			e := New(memcache.New(), WithMaxItemSize(size))
+			assert.Equal(t, defaultMaxItemSize, e.maxItemSize)
+		}
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := New(memcache.New(), WithKeyGenerator(&testKeyGenerator{}))
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := New(newFakeServer(t).client())
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		s := newFakeServer(t)
+		e := New(s.client())
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+
+		item, ok := s.item("key1")
+		assert.True(t, ok)
+		assert.Equal(t, int32(3600), item.Expiration)
+	})
+
+	t.Run("keys memcached does not accept are hashed", func(t *testing.T) {
+		t.Parallel()
+		s := newFakeServer(t)
+		e := New(s.client())
+		for _, k := range []string{"key with spaces", strings.Repeat("k", 300)} {
+			err := e.Set(ctx, k, testutil.NewResponse(t, req, "OK\n"), time.Hour)
+			assert.NoError(t, err)
+			_, ok, err := e.Get(ctx, k, req)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+		assert.Equal(t, 2, s.len())
+	})
+
+	t.Run("large responses are chunked", func(t *testing.T) {
+		t.Parallel()
+		s := newFakeServer(t)
+		e := New(s.client(), WithMaxItemSize(16))
+		body := strings.Repeat("0123456789", 10)
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, body), time.Hour)
+		assert.NoError(t, err)
+		assert.Greater(t, s.len(), 2)
+
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(resb))
+	})
+
+	t.Run("chunked responses with an evicted chunk are missed", func(t *testing.T) {
+		t.Parallel()
+		s := newFakeServer(t)
+		e := New(s.client(), WithMaxItemSize(16))
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, strings.Repeat("0123456789", 10)), time.Hour)
+		assert.NoError(t, err)
+		item, _ := s.item("key1")
+		generation, _, _ := parseChunked(item.Value)
+		assert.NoError(t, s.client().Delete(chunkKey("key1", generation, 1)))
+
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("responses exceeding the maximum chunks are rejected", func(t *testing.T) {
+		t.Parallel()
+		s := newFakeServer(t)
+		e := New(s.client(), WithMaxItemSize(16), WithMaxChunks(1))
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, strings.Repeat("0123456789", 10)), time.Hour)
+		assert.ErrorIs(t, err, ErrTooLarge)
+		assert.Equal(t, 0, s.len())
+	})
+}
+
+func TestCacheEngineExpiration(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+	e := New(memcache.New())
+	e.now = func() time.Time { return now }
+
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want int32
+	}{
+		{"seconds", time.Minute, 60},
+		{"rounded up", 1500 * time.Millisecond, 2},
+		{"less than a second", time.Millisecond, 1},
+		{"longer than 30 days", 31 * 24 * time.Hour, int32(now.Unix()) + 31*24*60*60},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, e.expiration(tt.ttl))
+		})
+	}
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := New(newFakeServer(t).client())
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
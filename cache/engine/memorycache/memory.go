@@ -0,0 +1,193 @@
+package memorycache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+// CacheEngine stores responses in process memory.
+// When the number of entries or the total size of stored responses exceeds the limit, the least recently used entries are evicted.
+type CacheEngine struct {
+	keyGenerator key.KeyGenerator
+	maxEntries   int
+	maxBytes     int64
+	now          func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+type lruEntry struct {
+	key       string
+	resb      []byte
+	expiresAt time.Time
+}
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = maxEntriesOption{}
+	_ Option = maxBytesOption{}
+)
+
+type options struct {
+	keyGenerator key.KeyGenerator
+	maxEntries   int
+	maxBytes     int64
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type maxEntriesOption struct {
+	maxEntries int
+}
+
+func (o maxEntriesOption) apply(opts *options) {
+	opts.maxEntries = o.maxEntries
+}
+
+// WithMaxEntries limits the number of stored responses. Zero or less means no limit.
+func WithMaxEntries(maxEntries int) maxEntriesOption {
+	return maxEntriesOption{maxEntries}
+}
+
+type maxBytesOption struct {
+	maxBytes int64
+}
+
+func (o maxBytesOption) apply(opts *options) {
+	opts.maxBytes = o.maxBytes
+}
+
+// WithMaxBytes limits the total size of stored responses in bytes. Zero or less means no limit.
+// A response larger than the limit is not stored.
+func WithMaxBytes(maxBytes int64) maxBytesOption {
+	return maxBytesOption{maxBytes}
+}
+
+func New(opts ...Option) *CacheEngine {
+	options := &options{
+		keyGenerator: key.NewKeyGenerator(""),
+		maxEntries:   0,
+		maxBytes:     0,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	return &CacheEngine{
+		keyGenerator: options.keyGenerator,
+		maxEntries:   options.maxEntries,
+		maxBytes:     options.maxBytes,
+		now:          time.Now,
+		entries:      map[string]*list.Element{},
+		lru:          list.New(),
+	}
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	return e.keyGenerator.Key(req)
+}
+
+func (e *CacheEngine) Get(_ context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	resb, ok := e.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	res, err := entry.Unmarshal(resb, req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+func (e *CacheEngine) get(key string) ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	elem, ok := e.entries[key]
+	if !ok {
+		return nil, false
+	}
+	ent := elem.Value.(*lruEntry)
+	if !e.now().Before(ent.expiresAt) {
+		e.remove(elem)
+		return nil, false
+	}
+	e.lru.MoveToFront(elem)
+	return ent.resb, true
+}
+
+// Set stores the response for ttl. A response with a non-positive ttl is not stored.
+func (e *CacheEngine) Set(_ context.Context, key string, res *http.Response, ttl time.Duration) error {
+	resb, err := entry.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if elem, ok := e.entries[key]; ok {
+		e.remove(elem)
+	}
+	if ttl <= 0 || (e.maxBytes > 0 && int64(len(resb)) > e.maxBytes) {
+		return nil
+	}
+	ent := &lruEntry{key: key, resb: resb, expiresAt: e.now().Add(ttl)}
+	e.entries[key] = e.lru.PushFront(ent)
+	e.bytes += int64(len(resb))
+	e.evict()
+	return nil
+}
+
+func (e *CacheEngine) Delete(_ context.Context, key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if elem, ok := e.entries[key]; ok {
+		e.remove(elem)
+	}
+	return nil
+}
+
+// Len returns the number of stored responses, including expired ones not yet evicted.
+func (e *CacheEngine) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lru.Len()
+}
+
+// evict removes the least recently used entries until the limits are satisfied.
+func (e *CacheEngine) evict() {
+	for (e.maxEntries > 0 && e.lru.Len() > e.maxEntries) || (e.maxBytes > 0 && e.bytes > e.maxBytes) {
+		e.remove(e.lru.Back())
+	}
+}
+
+func (e *CacheEngine) remove(elem *list.Element) {
+	ent := e.lru.Remove(elem).(*lruEntry)
+	delete(e.entries, ent.key)
+	e.bytes -= int64(len(ent.resb))
+}
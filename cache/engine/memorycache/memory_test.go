@@ -0,0 +1,183 @@
+package memorycache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func encodedSize(t *testing.T, req *http.Request) int64 {
+	t.Helper()
+	resb, err := entry.Marshal(testutil.NewResponse(t, req, "OK\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(resb))
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := New()
+		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, 0, e.maxEntries)
+		assert.Equal(t, int64(0), e.maxBytes)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := New(WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+
+	t.Run("WithMaxEntries and WithMaxBytes", func(t *testing.T) {
+		t.Parallel()
+		e := New(WithMaxEntries(10), WithMaxBytes(1024))
+		assert.Equal(t, 10, e.maxEntries)
+		assert.Equal(t, int64(1024), e.maxBytes)
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := New(WithKeyGenerator(&testKeyGenerator{}))
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := New()
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		e := New()
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+
+	t.Run("expired entries are missed", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		e := New()
+		e.now = func() time.Time { return now }
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Minute)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Minute)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, 0, e.Len())
+	})
+
+	t.Run("non-positive ttl is not stored", func(t *testing.T) {
+		t.Parallel()
+		e := New()
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		err = e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), 0)
+		assert.NoError(t, err)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCacheEngineEviction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("least recently used entries are evicted over max entries", func(t *testing.T) {
+		t.Parallel()
+		e := New(WithMaxEntries(2))
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e.Set(ctx, "key2", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		_, ok, _ := e.Get(ctx, "key1", req)
+		assert.True(t, ok)
+		assert.NoError(t, e.Set(ctx, "key3", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+
+		assert.Equal(t, 2, e.Len())
+		_, ok, _ = e.Get(ctx, "key1", req)
+		assert.True(t, ok)
+		_, ok, _ = e.Get(ctx, "key2", req)
+		assert.False(t, ok)
+		_, ok, _ = e.Get(ctx, "key3", req)
+		assert.True(t, ok)
+	})
+
+	t.Run("least recently used entries are evicted over max bytes", func(t *testing.T) {
+		t.Parallel()
+		size := encodedSize(t, req)
+		e := New(WithMaxBytes(size * 2))
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e.Set(ctx, "key2", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e.Set(ctx, "key3", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+
+		assert.Equal(t, 2, e.Len())
+		assert.Equal(t, size*2, e.bytes)
+		_, ok, _ := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+	})
+
+	t.Run("responses larger than max bytes are not stored", func(t *testing.T) {
+		t.Parallel()
+		e := New(WithMaxBytes(encodedSize(t, req) - 1))
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.Equal(t, 0, e.Len())
+		assert.Equal(t, int64(0), e.bytes)
+	})
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := New()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), e.bytes)
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,60 @@
+package rediscache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockKeySuffix is appended to cache keys to make lock keys, which are stored in the same slot as the cache keys with hash tags.
+const lockKeySuffix = ":lock"
+
+// unlockScript deletes the lock only if it is still held by the token, so that a lock expired and acquired by another is not released.
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+
+// Locker is a lock on cache keys shared by processes through Redis, acquired with SET NX PX.
+type Locker struct {
+	redisCli LockClient
+}
+
+var _ engine.Locker = (*Locker)(nil)
+
+type LockClient interface {
+	SetNX(ctx context.Context, key string, value any, ttl time.Duration) *redis.BoolCmd
+	Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd
+}
+
+func NewLocker(redisCli LockClient) *Locker {
+	return &Locker{redisCli: redisCli}
+}
+
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(ctx context.Context) error, bool, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, false, err
+	}
+	lockKey := key + lockKeySuffix
+	ok, err := l.redisCli.SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	unlock := func(ctx context.Context) error {
+		return l.redisCli.Eval(ctx, unlockScript, []string{lockKey}, token).Err()
+	}
+	return unlock, true, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
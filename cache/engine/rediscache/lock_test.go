@@ -0,0 +1,84 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockerTryLock(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("lock is exclusive until unlocked", func(t *testing.T) {
+		t.Parallel()
+		rs, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Close()
+		l := NewLocker(redis.NewClient(&redis.Options{Addr: rs.Addr()}))
+
+		unlock, ok, err := l.TryLock(ctx, "key1", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, rs.Exists("key1:lock"))
+		assert.InDelta(t, time.Minute, rs.TTL("key1:lock"), float64(time.Second))
+
+		_, ok, err = l.TryLock(ctx, "key1", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		// other keys are not locked
+		_, ok, err = l.TryLock(ctx, "key2", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		assert.NoError(t, unlock(ctx))
+		assert.False(t, rs.Exists("key1:lock"))
+		_, ok, err = l.TryLock(ctx, "key1", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("lock expires", func(t *testing.T) {
+		t.Parallel()
+		rs, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Close()
+		l := NewLocker(redis.NewClient(&redis.Options{Addr: rs.Addr()}))
+
+		unlock, ok, err := l.TryLock(ctx, "key1", time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		rs.FastForward(time.Second)
+		unlock2, ok, err := l.TryLock(ctx, "key1", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		// the expired lock does not release the lock acquired by another
+		assert.NoError(t, unlock(ctx))
+		assert.True(t, rs.Exists("key1:lock"))
+		assert.NoError(t, unlock2(ctx))
+		assert.False(t, rs.Exists("key1:lock"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		rs, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := NewLocker(redis.NewClient(&redis.Options{Addr: rs.Addr()}))
+		rs.Close()
+
+		_, ok, err := l.TryLock(ctx, "key1", time.Minute)
+		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+}
@@ -1,15 +1,13 @@
 package rediscache
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"net/http"
-	"net/http/httputil"
 	"time"
 
 	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
 	"github.com/Arthur1/http-client-cache/cache/key"
 	"github.com/go-redis/cache/v9"
 	"github.com/redis/go-redis/v9"
@@ -18,6 +16,8 @@ import (
 type CacheEngine struct {
 	redisCache   *cache.Cache
 	keyGenerator key.KeyGenerator
+	codec        entry.Codec
+	hashTag      bool
 }
 
 var _ engine.CacheEngine = (*CacheEngine)(nil)
@@ -36,12 +36,16 @@ type Option interface {
 
 var (
 	_ Option = keyGeneratorOption{}
+	_ Option = codecOption{}
 	_ Option = localCacheOption{}
+	_ Option = hashTagOption{}
 )
 
 type options struct {
 	keyGenerator key.KeyGenerator
+	codec        entry.Codec
 	localCache   cache.LocalCache
+	hashTag      bool
 }
 
 type keyGeneratorOption struct {
@@ -56,6 +60,19 @@ func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
 	return keyGeneratorOption{keyGenerator}
 }
 
+type codecOption struct {
+	codec entry.Codec
+}
+
+func (o codecOption) apply(opts *options) {
+	opts.codec = o.codec
+}
+
+// WithCodec specifies how responses are serialized (default entry.BinaryCodec).
+func WithCodec(codec entry.Codec) codecOption {
+	return codecOption{codec}
+}
+
 type localCacheOption struct {
 	localCache cache.LocalCache
 }
@@ -68,10 +85,28 @@ func WithLocalCache(localCache cache.LocalCache) localCacheOption {
 	return localCacheOption{localCache}
 }
 
+type hashTagOption struct {
+	hashTag bool
+}
+
+func (o hashTagOption) apply(opts *options) {
+	opts.hashTag = o.hashTag
+}
+
+// WithHashTag wraps keys in a hash tag like "{key}".
+// Keys derived from a key by appending a suffix, such as those of the variants of a response, are then stored in the same slot of Redis Cluster.
+func WithHashTag(hashTag bool) hashTagOption {
+	return hashTagOption{hashTag}
+}
+
+// New returns a CacheEngine using a Redis client.
+// It also accepts a failover client created by redis.NewFailoverClient, which follows the master switched by Redis Sentinel.
 func New(redisCli RedisClient, opts ...Option) *CacheEngine {
 	options := &options{
 		keyGenerator: key.NewKeyGenerator(""),
+		codec:        entry.BinaryCodec{},
 		localCache:   nil,
+		hashTag:      false,
 	}
 	for _, o := range opts {
 		o.apply(options)
@@ -84,11 +119,26 @@ func New(redisCli RedisClient, opts ...Option) *CacheEngine {
 	return &CacheEngine{
 		redisCache:   redisCache,
 		keyGenerator: options.keyGenerator,
+		codec:        options.codec,
+		hashTag:      options.hashTag,
 	}
 }
 
-func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
-	return e.keyGenerator.Key(req)
+// NewCluster returns a CacheEngine using a Redis Cluster client, with hash tags enabled by default.
+// A client created by redis.NewFailoverClusterClient is also accepted.
+func NewCluster(clusterCli *redis.ClusterClient, opts ...Option) *CacheEngine {
+	return New(clusterCli, append([]Option{WithHashTag(true)}, opts...)...)
+}
+
+func (e *CacheEngine) Key(req *http.Request) (string, error) {
+	key, err := e.keyGenerator.Key(req)
+	if err != nil {
+		return "", err
+	}
+	if e.hashTag {
+		return "{" + key + "}", nil
+	}
+	return key, nil
 }
 
 func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*http.Response, bool, error) {
@@ -99,7 +149,7 @@ func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*
 		}
 		return nil, false, err
 	}
-	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resb)), req)
+	res, err := entry.DecodeResponse(e.codec, resb, req)
 	if err != nil {
 		return nil, false, err
 	}
@@ -107,7 +157,7 @@ func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*
 }
 
 func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, ttl time.Duration) error {
-	resb, err := httputil.DumpResponse(res, true)
+	resb, err := entry.EncodeResponse(e.codec, res)
 	if err != nil {
 		return err
 	}
@@ -119,3 +169,7 @@ func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, t
 	}
 	return e.redisCache.Set(item)
 }
+
+func (e *CacheEngine) Delete(ctx context.Context, key string) error {
+	return e.redisCache.Delete(ctx, key)
+}
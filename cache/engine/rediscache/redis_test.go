@@ -4,13 +4,19 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Arthur1/http-client-cache/cache/entry"
 	"github.com/Arthur1/http-client-cache/cache/key"
 	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
 	"github.com/go-redis/cache/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -29,6 +35,7 @@ func TestNew(t *testing.T) {
 		redisCli := redis.NewClient(&redis.Options{})
 		e := New(redisCli)
 		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, entry.BinaryCodec{}, e.codec)
 		assert.NotEmpty(t, e.redisCache)
 	})
 
@@ -40,6 +47,27 @@ func TestNew(t *testing.T) {
 		assert.Equal(t, keyGenerator, e.keyGenerator)
 	})
 
+	t.Run("WithCodec", func(t *testing.T) {
+		t.Parallel()
+		redisCli := redis.NewClient(&redis.Options{})
+		e := New(redisCli, WithCodec(entry.DumpCodec{}))
+		assert.Equal(t, entry.DumpCodec{}, e.codec)
+	})
+
+	t.Run("WithHashTag", func(t *testing.T) {
+		t.Parallel()
+		redisCli := redis.NewClient(&redis.Options{})
+		e := New(redisCli, WithHashTag(true))
+		assert.True(t, e.hashTag)
+	})
+
+	t.Run("NewCluster", func(t *testing.T) {
+		t.Parallel()
+		clusterCli := redis.NewClusterClient(&redis.ClusterOptions{})
+		assert.True(t, NewCluster(clusterCli).hashTag)
+		assert.False(t, NewCluster(clusterCli, WithHashTag(false)).hashTag)
+	})
+
 	t.Run("WithLocalCache", func(t *testing.T) {
 		t.Parallel()
 		redisCli := redis.NewClient(&redis.Options{})
@@ -50,12 +78,25 @@ func TestNew(t *testing.T) {
 
 func TestCacheEngineKey(t *testing.T) {
 	t.Parallel()
-	redisCli := redis.NewClient(&redis.Options{})
-	keyGenerator := &testKeyGenerator{}
-	e := New(redisCli, WithKeyGenerator(keyGenerator))
-	got, err := e.Key(nil)
-	assert.NoError(t, err)
-	assert.Equal(t, "test", got)
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		redisCli := redis.NewClient(&redis.Options{})
+		keyGenerator := &testKeyGenerator{}
+		e := New(redisCli, WithKeyGenerator(keyGenerator))
+		got, err := e.Key(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "test", got)
+	})
+
+	t.Run("WithHashTag", func(t *testing.T) {
+		t.Parallel()
+		redisCli := redis.NewClient(&redis.Options{})
+		keyGenerator := &testKeyGenerator{}
+		e := New(redisCli, WithKeyGenerator(keyGenerator), WithHashTag(true))
+		got, err := e.Key(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "{test}", got)
+	})
 }
 
 func TestCacheEngineGetAndSet(t *testing.T) {
@@ -99,4 +140,220 @@ func TestCacheEngineGetAndSet(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "OK\n", string(resb))
 	})
+
+	t.Run("set and cache hit with each codec", func(t *testing.T) {
+		t.Parallel()
+		rs, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		redisCli := redis.NewClient(&redis.Options{Addr: rs.Addr(), DB: 0})
+		ctx := context.Background()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		binaryPayload := "\x00\xff\r\n\r\n\x80"
+		serializedResMock := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+			fmt.Sprintf("%x\r\n%s\r\n0\r\nX-Checksum: abc\r\n\r\n", len(binaryPayload), binaryPayload)
+
+		for _, codec := range []entry.Codec{entry.BinaryCodec{}, entry.DumpCodec{}, entry.MsgpackCodec{}} {
+			e := New(redisCli, WithCodec(codec))
+			resMock, _ := http.ReadResponse(bufio.NewReader(strings.NewReader(serializedResMock)), req)
+			key := fmt.Sprintf("%T", codec)
+			assert.NoError(t, e.Set(ctx, key, resMock, time.Hour))
+
+			res, ok, err := e.Get(ctx, key, req)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			resb, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, binaryPayload, string(resb), key)
+			assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"), key)
+		}
+	})
+
+	t.Run("responses stored in the legacy format are read", func(t *testing.T) {
+		t.Parallel()
+		rs, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		redisCli := redis.NewClient(&redis.Options{Addr: rs.Addr(), DB: 0})
+		e := New(redisCli)
+		ctx := context.Background()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		err = e.redisCache.Set(&cache.Item{Ctx: ctx, Key: "key1", Value: []byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nOK\n"), TTL: time.Hour})
+		assert.NoError(t, err)
+
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	rs, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisCli := redis.NewClient(&redis.Options{Addr: rs.Addr(), DB: 0})
+	e := New(redisCli)
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	serializedResMock := []byte("HTTP/1.1 200 OK\nContent-Length: 3\n\nOK\n")
+	resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(serializedResMock)), req)
+
+	err = e.Set(ctx, "key1", resMock, time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
+
+// newCluster returns a Redis Cluster client whose slots are split between two miniredis nodes.
+func newCluster(t *testing.T) (*redis.ClusterClient, *miniredis.Miniredis, *miniredis.Miniredis) {
+	t.Helper()
+	rs1, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs2, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		rs1.Close()
+		rs2.Close()
+	})
+	clusterCli := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: rs1.Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: rs2.Addr()}}},
+			}, nil
+		},
+	})
+	t.Cleanup(func() { clusterCli.Close() })
+	return clusterCli, rs1, rs2
+}
+
+func TestCacheEngineWithCluster(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	serializedResMock := []byte("HTTP/1.1 200 OK\nContent-Length: 3\n\nOK\n")
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		clusterCli, rs1, rs2 := newCluster(t)
+		e := NewCluster(clusterCli)
+		for i := 0; i < 10; i++ {
+			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("https://example.com/%d", i), nil)
+			resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(serializedResMock)), req)
+			key, err := e.Key(req)
+			assert.NoError(t, err)
+			assert.NoError(t, e.Set(ctx, key, resMock, time.Hour))
+			_, ok, err := e.Get(ctx, key, req)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+		assert.NotEmpty(t, rs1.Keys())
+		assert.NotEmpty(t, rs2.Keys())
+	})
+
+	t.Run("keys derived from a key are stored in the same node with hash tags", func(t *testing.T) {
+		t.Parallel()
+		colocated := func(e *CacheEngine, rs1 *miniredis.Miniredis) bool {
+			for i := 0; i < 20; i++ {
+				req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("https://example.com/%d", i), nil)
+				resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(serializedResMock)), req)
+				key, err := e.Key(req)
+				assert.NoError(t, err)
+				assert.NoError(t, e.Set(ctx, key, resMock, time.Hour))
+				assert.NoError(t, e.Set(ctx, key+"_vary_0", resMock, time.Hour))
+				if rs1.Exists(key) != rs1.Exists(key+"_vary_0") {
+					return false
+				}
+			}
+			return true
+		}
+
+		clusterCli, rs1, _ := newCluster(t)
+		assert.True(t, colocated(NewCluster(clusterCli), rs1))
+		clusterCli, rs1, _ = newCluster(t)
+		assert.False(t, colocated(NewCluster(clusterCli, WithHashTag(false)), rs1))
+	})
+}
+
+// newSentinel runs a miniredis answering the SENTINEL commands used by failover clients with the address returned by master.
+func newSentinel(t *testing.T, master func() string) *miniredis.Miniredis {
+	t.Helper()
+	sentinel, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sentinel.Close)
+	err = sentinel.Server().Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch strings.ToUpper(args[0]) {
+		case "GET-MASTER-ADDR-BY-NAME":
+			host, port, _ := net.SplitHostPort(master())
+			c.WriteStrings([]string{host, port})
+		default:
+			c.WriteLen(0)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sentinel
+}
+
+func TestCacheEngineWithFailover(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	rs1, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs1.Close()
+	rs2, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs2.Close()
+	var master atomic.Value
+	master.Store(rs1.Addr())
+	sentinel := newSentinel(t, func() string { return master.Load().(string) })
+
+	redisCli := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    "mymaster",
+		SentinelAddrs: []string{sentinel.Addr()},
+	})
+	defer redisCli.Close()
+	e := New(redisCli)
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	serializedResMock := []byte("HTTP/1.1 200 OK\nContent-Length: 3\n\nOK\n")
+	resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(serializedResMock)), req)
+
+	assert.NoError(t, e.Set(ctx, "key1", resMock, time.Hour))
+	assert.True(t, rs1.Exists("key1"))
+
+	// the sentinel promotes rs2
+	master.Store(rs2.Addr())
+	host1, port1, _ := net.SplitHostPort(rs1.Addr())
+	host2, port2, _ := net.SplitHostPort(rs2.Addr())
+	sentinel.Publish("+switch-master", strings.Join([]string{"mymaster", host1, port1, host2, port2}, " "))
+
+	assert.Eventually(t, func() bool {
+		resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader(serializedResMock)), req)
+		return e.Set(ctx, "key2", resMock, time.Hour) == nil && rs2.Exists("key2")
+	}, 3*time.Second, 50*time.Millisecond)
 }
@@ -0,0 +1,153 @@
+package shardedcache
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+// Shard is a child engine of CacheEngine.
+type Shard struct {
+	// Name identifies the shard in hashing. Keys stay on the same shard as long as its name is unchanged, regardless of the order of shards.
+	Name   string
+	Engine engine.CacheEngine
+	// Weight is the relative share of keys assigned to the shard. Zero or less is regarded as 1.
+	Weight float64
+}
+
+// CacheEngine distributes keys across shards with weighted rendezvous hashing,
+// so that adding or removing a shard moves only the keys assigned to it.
+type CacheEngine struct {
+	shards       []Shard
+	keyGenerator key.KeyGenerator
+	errorHandler func(shard Shard, err error)
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = errorHandlerOption{}
+)
+
+type options struct {
+	keyGenerator key.KeyGenerator
+	errorHandler func(shard Shard, err error)
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+// WithKeyGenerator sets the key generator. By default, the key of the first shard is used.
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type errorHandlerOption struct {
+	errorHandler func(shard Shard, err error)
+}
+
+func (o errorHandlerOption) apply(opts *options) {
+	opts.errorHandler = o.errorHandler
+}
+
+// WithErrorHandler sets the function called when a shard fails to get, which is otherwise regarded as a miss silently.
+func WithErrorHandler(errorHandler func(shard Shard, err error)) errorHandlerOption {
+	return errorHandlerOption{errorHandler}
+}
+
+// New returns a CacheEngine distributing keys across shards. shards must not be empty, and their names must be unique.
+func New(shards []Shard, opts ...Option) *CacheEngine {
+	options := &options{
+		keyGenerator: nil,
+		errorHandler: func(Shard, error) {},
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	return &CacheEngine{
+		shards:       shards,
+		keyGenerator: options.keyGenerator,
+		errorHandler: options.errorHandler,
+	}
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	if e.keyGenerator != nil {
+		return e.keyGenerator.Key(req)
+	}
+	return e.shards[0].Engine.Key(req)
+}
+
+// Get returns the response from the shard of the key. A failure of the shard is regarded as a miss.
+func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	shard := e.shard(key)
+	res, ok, err := shard.Engine.Get(ctx, key, req)
+	if err != nil {
+		e.errorHandler(shard, err)
+		return nil, false, nil
+	}
+	return res, ok, nil
+}
+
+func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, ttl time.Duration) error {
+	return e.shard(key).Engine.Set(ctx, key, res, ttl)
+}
+
+func (e *CacheEngine) Delete(ctx context.Context, key string) error {
+	return e.shard(key).Engine.Delete(ctx, key)
+}
+
+// shard returns the shard with the highest weighted rendezvous score for the key.
+func (e *CacheEngine) shard(key string) Shard {
+	var (
+		best      Shard
+		bestScore = math.Inf(-1)
+	)
+	for _, shard := range e.shards {
+		if score := score(shard, key); score > bestScore {
+			best, bestScore = shard, score
+		}
+	}
+	return best
+}
+
+// score computes -weight / ln(u), where u is the hash of the shard and the key mapped to (0, 1).
+// The shard with the highest score wins with the probability proportional to its weight.
+func score(shard Shard, key string) float64 {
+	weight := shard.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	h := fnv.New64a()
+	h.Write([]byte(shard.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	u := (float64(mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+	return -weight / math.Log(u)
+}
+
+// mix is the finalizer of SplitMix64, spreading the bits of FNV hashes of similar inputs.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,163 @@
+package shardedcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine/memorycache"
+	mock_engine "github.com/Arthur1/http-client-cache/cache/engine/mock"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func newShards(names ...string) []Shard {
+	shards := make([]Shard, len(names))
+	for i, name := range names {
+		shards[i] = Shard{Name: name, Engine: memorycache.New()}
+	}
+	return shards
+}
+
+func distribution(e *CacheEngine, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		counts[e.shard(fmt.Sprintf("key%d", i)).Name]++
+	}
+	return counts
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := New(newShards("a"))
+		assert.Nil(t, e.keyGenerator)
+		assert.NotNil(t, e.errorHandler)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := New(newShards("a"), WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := New([]Shard{
+		{Name: "a", Engine: memorycache.New(memorycache.WithKeyGenerator(&testKeyGenerator{}))},
+		{Name: "b", Engine: memorycache.New()},
+	})
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineShard(t *testing.T) {
+	t.Parallel()
+	t.Run("Keys are distributed evenly", func(t *testing.T) {
+		t.Parallel()
+		e := New(newShards("a", "b", "c", "d"))
+		for name, count := range distribution(e, 10000) {
+			assert.InDelta(t, 2500, count, 250, name)
+		}
+	})
+
+	t.Run("Keys are distributed by weight", func(t *testing.T) {
+		t.Parallel()
+		shards := newShards("a", "b")
+		shards[1].Weight = 3
+		e := New(shards)
+		counts := distribution(e, 10000)
+		assert.InDelta(t, 2500, counts["a"], 250)
+		assert.InDelta(t, 7500, counts["b"], 250)
+	})
+
+	t.Run("Order of shards does not matter", func(t *testing.T) {
+		t.Parallel()
+		e1 := New(newShards("a", "b", "c"))
+		e2 := New(newShards("c", "a", "b"))
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key%d", i)
+			assert.Equal(t, e1.shard(key).Name, e2.shard(key).Name)
+		}
+	})
+
+	t.Run("Removing a shard moves only its keys", func(t *testing.T) {
+		t.Parallel()
+		e1 := New(newShards("a", "b", "c"))
+		e2 := New(newShards("a", "b"))
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if name := e1.shard(key).Name; name != "c" {
+				assert.Equal(t, name, e2.shard(key).Name)
+			}
+		}
+	})
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("set to and get from the shard of the key", func(t *testing.T) {
+		t.Parallel()
+		e := New(newShards("a", "b", "c"))
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		for _, shard := range e.shards {
+			_, ok, err := shard.Engine.Get(ctx, "key1", req)
+			assert.NoError(t, err)
+			assert.Equal(t, shard.Name == e.shard("key1").Name, ok)
+		}
+	})
+
+	t.Run("failing shards are regarded as misses", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key1", gomock.Any()).Return(nil, false, errors.New("error"))
+
+		var handled []string
+		e := New([]Shard{{Name: "a", Engine: cacheEngineMock}}, WithErrorHandler(func(shard Shard, err error) {
+			handled = append(handled, shard.Name)
+		}))
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, []string{"a"}, handled)
+	})
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := New(newShards("a", "b", "c"))
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
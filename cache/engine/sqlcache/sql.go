@@ -0,0 +1,280 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+// Dialect absorbs the differences of SQL among databases.
+type Dialect int
+
+const (
+	// DialectSQLite is for SQLite, using ? placeholders.
+	DialectSQLite Dialect = iota
+	// DialectPostgres is for PostgreSQL, using $n placeholders.
+	DialectPostgres
+)
+
+const (
+	defaultTableName       = "http_client_cache"
+	defaultCleanupInterval = time.Minute
+)
+
+// CacheEngine stores responses in a table through database/sql.
+// The table must be created by CreateSchema beforehand.
+// Each row holds the status line and header fields of a response as metadata, separately from its body.
+type CacheEngine struct {
+	db           *sql.DB
+	keyGenerator key.KeyGenerator
+	tableName    string
+	dialect      Dialect
+	now          func() time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = tableNameOption{}
+	_ Option = dialectOption{}
+	_ Option = cleanupIntervalOption{}
+)
+
+type options struct {
+	keyGenerator    key.KeyGenerator
+	tableName       string
+	dialect         Dialect
+	cleanupInterval time.Duration
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type tableNameOption struct {
+	tableName string
+}
+
+func (o tableNameOption) apply(opts *options) {
+	opts.tableName = o.tableName
+}
+
+// WithTableName sets the name of the table. It is embedded in SQL as is, so it must not come from untrusted input.
+func WithTableName(tableName string) tableNameOption {
+	return tableNameOption{tableName}
+}
+
+type dialectOption struct {
+	dialect Dialect
+}
+
+func (o dialectOption) apply(opts *options) {
+	opts.dialect = o.dialect
+}
+
+func WithDialect(dialect Dialect) dialectOption {
+	return dialectOption{dialect}
+}
+
+type cleanupIntervalOption struct {
+	cleanupInterval time.Duration
+}
+
+func (o cleanupIntervalOption) apply(opts *options) {
+	opts.cleanupInterval = o.cleanupInterval
+}
+
+// WithCleanupInterval sets the interval of deleting expired rows in the background. Zero or less disables it.
+func WithCleanupInterval(cleanupInterval time.Duration) cleanupIntervalOption {
+	return cleanupIntervalOption{cleanupInterval}
+}
+
+// New returns a CacheEngine using db and starts the background cleanup.
+// Close must be called to stop it. db is not closed by the CacheEngine.
+func New(db *sql.DB, opts ...Option) *CacheEngine {
+	options := &options{
+		keyGenerator:    key.NewKeyGenerator(""),
+		tableName:       defaultTableName,
+		dialect:         DialectSQLite,
+		cleanupInterval: defaultCleanupInterval,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	e := &CacheEngine{
+		db:           db,
+		keyGenerator: options.keyGenerator,
+		tableName:    options.tableName,
+		dialect:      options.dialect,
+		now:          time.Now,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go e.run(options.cleanupInterval)
+	return e
+}
+
+// CreateSchema creates the table and its index if they do not exist.
+func (e *CacheEngine) CreateSchema(ctx context.Context) error {
+	blob := "BLOB"
+	if e.dialect == DialectPostgres {
+		blob = "BYTEA"
+	}
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	key VARCHAR(1024) PRIMARY KEY,
+	stored_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	metadata %s NOT NULL,
+	body %s NOT NULL
+)`, e.tableName, blob, blob),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)`, e.tableName, e.tableName),
+	}
+	for _, stmt := range stmts {
+		if _, err := e.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run cleans up periodically until Close is called.
+func (e *CacheEngine) run(cleanupInterval time.Duration) {
+	defer close(e.done)
+	if cleanupInterval <= 0 {
+		<-e.stop
+		return
+	}
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			_ = e.Cleanup(context.Background())
+		}
+	}
+}
+
+// Close stops the background cleanup. Only the first call takes effect.
+func (e *CacheEngine) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+	})
+	return nil
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	return e.keyGenerator.Key(req)
+}
+
+func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	var metadata, body []byte
+	query := e.query(`SELECT metadata, body FROM %s WHERE key = ? AND expires_at > ?`)
+	err := e.db.QueryRowContext(ctx, query, key, e.now().UnixMilli()).Scan(&metadata, &body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	// the body follows the rest of the encoded entry
+	res, err := entry.Unmarshal(append(metadata, body...), req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// Set stores the response. A response with a non-positive ttl is not stored.
+func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, ttl time.Duration) error {
+	metadata, body, err := encode(res)
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return e.Delete(ctx, key)
+	}
+	now := e.now()
+	query := e.query(`INSERT INTO %s (key, stored_at, expires_at, metadata, body) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (key) DO UPDATE SET stored_at = excluded.stored_at, expires_at = excluded.expires_at, metadata = excluded.metadata, body = excluded.body`)
+	_, err = e.db.ExecContext(ctx, query, key, now.UnixMilli(), now.Add(ttl).UnixMilli(), metadata, body)
+	return err
+}
+
+func (e *CacheEngine) Delete(ctx context.Context, key string) error {
+	_, err := e.db.ExecContext(ctx, e.query(`DELETE FROM %s WHERE key = ?`), key)
+	return err
+}
+
+// Cleanup deletes expired rows.
+func (e *CacheEngine) Cleanup(ctx context.Context) error {
+	_, err := e.db.ExecContext(ctx, e.query(`DELETE FROM %s WHERE expires_at <= ?`), e.now().UnixMilli())
+	return err
+}
+
+// query embeds the table name and rewrites the placeholders for the dialect.
+func (e *CacheEngine) query(format string) string {
+	q := fmt.Sprintf(format, e.tableName)
+	if e.dialect != DialectPostgres {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// encode encodes the response into the entry without the body, and the body.
+func encode(res *http.Response) (metadata, body []byte, err error) {
+	ent, err := entry.New(res)
+	if err != nil {
+		return nil, nil, err
+	}
+	body = ent.Body
+	if body == nil {
+		body = []byte{}
+	}
+	ent.Body = nil
+	metadata, err = ent.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return metadata, body, nil
+}
@@ -0,0 +1,248 @@
+//go:build cgo
+
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func newDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newEngine(t *testing.T, opts ...Option) *CacheEngine {
+	t.Helper()
+	e := New(newDB(t), opts...)
+	t.Cleanup(func() { e.Close() })
+	if err := e.CreateSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func countRows(t *testing.T, e *CacheEngine) int {
+	t.Helper()
+	var n int
+	err := e.db.QueryRow(e.query(`SELECT COUNT(*) FROM %s`)).Scan(&n)
+	assert.NoError(t, err)
+	return n
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		assert.IsType(t, &key.DefaultKeyGenerator{}, e.keyGenerator)
+		assert.Equal(t, defaultTableName, e.tableName)
+		assert.Equal(t, DialectSQLite, e.dialect)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := newEngine(t, WithKeyGenerator(keyGenerator))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+	})
+
+	t.Run("WithTableName and WithDialect", func(t *testing.T) {
+		t.Parallel()
+		e := New(nil, WithTableName("responses"), WithDialect(DialectPostgres), WithCleanupInterval(0))
+		defer e.Close()
+		assert.Equal(t, "responses", e.tableName)
+		assert.Equal(t, DialectPostgres, e.dialect)
+	})
+}
+
+func TestCacheEngineCreateSchema(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t, WithTableName("responses"))
+	// creating the schema again is not an error
+	assert.NoError(t, e.CreateSchema(context.Background()))
+	assert.Equal(t, 0, countRows(t, e))
+}
+
+func TestCacheEngineQuery(t *testing.T) {
+	t.Parallel()
+	t.Run("SQLite", func(t *testing.T) {
+		t.Parallel()
+		e := &CacheEngine{tableName: "t", dialect: DialectSQLite}
+		assert.Equal(t, "SELECT body FROM t WHERE key = ? AND expires_at > ?", e.query("SELECT body FROM %s WHERE key = ? AND expires_at > ?"))
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		t.Parallel()
+		e := &CacheEngine{tableName: "t", dialect: DialectPostgres}
+		assert.Equal(t, "SELECT body FROM t WHERE key = $1 AND expires_at > $2", e.query("SELECT body FROM %s WHERE key = ? AND expires_at > ?"))
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	e := New(nil, WithKeyGenerator(&testKeyGenerator{}))
+	defer e.Close()
+	got, err := e.Key(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "test", got)
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set and cache hit", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+
+		var metadata, body []byte
+		err = e.db.QueryRow(e.query(`SELECT metadata, body FROM %s`)).Scan(&metadata, &body)
+		assert.NoError(t, err)
+		var ent entry.Entry
+		assert.NoError(t, ent.UnmarshalBinary(metadata))
+		assert.Equal(t, http.StatusOK, ent.StatusCode)
+		assert.Equal(t, "3", ent.Header.Get("Content-Length"))
+		assert.Empty(t, ent.Body)
+		assert.Equal(t, "OK\n", string(body))
+	})
+
+	t.Run("rows in the legacy format are read", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		query := e.query(`INSERT INTO %s (key, stored_at, expires_at, metadata, body) VALUES (?, ?, ?, ?, ?)`)
+		_, err := e.db.Exec(query, "key1", time.Now().UnixMilli(), time.Now().Add(time.Hour).UnixMilli(), []byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\n"), []byte("OK\n"))
+		assert.NoError(t, err)
+
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+
+	t.Run("overwriting replaces the row", func(t *testing.T) {
+		t.Parallel()
+		e := newEngine(t)
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour))
+		assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "NG\n"), time.Hour))
+		assert.Equal(t, 1, countRows(t, e))
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "NG\n", string(resb))
+	})
+
+	t.Run("expired rows are missed", func(t *testing.T) {
+		t.Parallel()
+		now := time.Now()
+		e := newEngine(t)
+		e.now = func() time.Time { return now }
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Minute)
+		assert.NoError(t, err)
+
+		now = now.Add(time.Minute)
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCacheEngineCleanup(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	now := time.Now()
+	e := newEngine(t, WithCleanupInterval(0))
+	e.now = func() time.Time { return now }
+	for i := 0; i < 10; i++ {
+		err := e.Set(ctx, fmt.Sprintf("key%d", i), testutil.NewResponse(t, req, "OK\n"), time.Duration(i+1)*time.Minute)
+		assert.NoError(t, err)
+	}
+
+	now = now.Add(5 * time.Minute)
+	assert.NoError(t, e.Cleanup(ctx))
+	assert.Equal(t, 5, countRows(t, e))
+}
+
+func TestCacheEngineBackgroundCleanup(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	e := newEngine(t, WithCleanupInterval(10*time.Millisecond))
+	assert.NoError(t, e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Millisecond))
+
+	assert.Eventually(t, func() bool {
+		return countRows(t, e) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheEngineClose(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t, WithCleanupInterval(time.Hour))
+	assert.NoError(t, e.Close())
+	assert.NoError(t, e.Close())
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	e := newEngine(t)
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	_, ok, err := e.Get(ctx, "key1", req)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// deleting a missing key is not an error
+	err = e.Delete(ctx, "key2")
+	assert.NoError(t, err)
+}
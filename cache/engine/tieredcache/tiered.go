@@ -0,0 +1,163 @@
+package tieredcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/entry"
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+// CacheEngine stacks cache engines, such as memory, file and Redis.
+// Get reads through the tiers in order, and Set and Delete are applied to all of them.
+type CacheEngine struct {
+	tiers        []engine.CacheEngine
+	keyGenerator key.KeyGenerator
+	backfillTTL  time.Duration
+}
+
+var _ engine.CacheEngine = (*CacheEngine)(nil)
+
+const defaultBackfillTTL = time.Minute
+
+type Option interface {
+	apply(opts *options)
+}
+
+var (
+	_ Option = keyGeneratorOption{}
+	_ Option = backfillTTLOption{}
+)
+
+type options struct {
+	keyGenerator key.KeyGenerator
+	backfillTTL  time.Duration
+}
+
+type keyGeneratorOption struct {
+	keyGenerator key.KeyGenerator
+}
+
+func (o keyGeneratorOption) apply(opts *options) {
+	opts.keyGenerator = o.keyGenerator
+}
+
+// WithKeyGenerator sets the key generator. By default, the key of the first tier is used.
+func WithKeyGenerator(keyGenerator key.KeyGenerator) keyGeneratorOption {
+	return keyGeneratorOption{keyGenerator}
+}
+
+type backfillTTLOption struct {
+	backfillTTL time.Duration
+}
+
+func (o backfillTTLOption) apply(opts *options) {
+	opts.backfillTTL = o.backfillTTL
+}
+
+// WithBackfillTTL sets the TTL of responses copied to upper tiers when they are found in a lower tier.
+// Since the remaining TTL in the lower tier is unknown, it should be short enough not to keep responses the lower tier has dropped.
+func WithBackfillTTL(backfillTTL time.Duration) backfillTTLOption {
+	return backfillTTLOption{backfillTTL}
+}
+
+// New returns a CacheEngine reading tiers in the given order. tiers must not be empty.
+func New(tiers []engine.CacheEngine, opts ...Option) *CacheEngine {
+	options := &options{
+		keyGenerator: nil,
+		backfillTTL:  defaultBackfillTTL,
+	}
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	return &CacheEngine{
+		tiers:        tiers,
+		keyGenerator: options.keyGenerator,
+		backfillTTL:  options.backfillTTL,
+	}
+}
+
+func (e *CacheEngine) Key(req *http.Request) (key string, err error) {
+	if e.keyGenerator != nil {
+		return e.keyGenerator.Key(req)
+	}
+	return e.tiers[0].Key(req)
+}
+
+// Get returns the response from the first tier having it, and copies it to the tiers above.
+// A tier failing to get is regarded as a miss, and its error is returned only if no tier has the response.
+func (e *CacheEngine) Get(ctx context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	var errs []error
+	for i, tier := range e.tiers {
+		res, ok, err := tier.Get(ctx, key, req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if i == 0 {
+			return res, true, nil
+		}
+		return e.backfill(ctx, key, req, res, e.tiers[:i])
+	}
+	return nil, false, errors.Join(errs...)
+}
+
+// backfill sets the response to the upper tiers. Failures to set are ignored because the response is still available.
+func (e *CacheEngine) backfill(ctx context.Context, key string, req *http.Request, res *http.Response, upper []engine.CacheEngine) (*http.Response, bool, error) {
+	resb, err := entry.Marshal(res)
+	if err != nil {
+		return nil, false, err
+	}
+	res.Body.Close()
+	for _, tier := range upper {
+		if tierRes, err := readResponse(resb, req); err == nil {
+			_ = tier.Set(ctx, key, tierRes, e.backfillTTL)
+		}
+	}
+	res, err = readResponse(resb, req)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// Set writes the response through to all tiers.
+func (e *CacheEngine) Set(ctx context.Context, key string, res *http.Response, ttl time.Duration) error {
+	resb, err := entry.Marshal(res)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, tier := range e.tiers {
+		tierRes, err := readResponse(resb, res.Request)
+		if err != nil {
+			return err
+		}
+		if err := tier.Set(ctx, key, tierRes, ttl); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Delete deletes the response from all tiers.
+func (e *CacheEngine) Delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, tier := range e.tiers {
+		if err := tier.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readResponse(resb []byte, req *http.Request) (*http.Response, error) {
+	return entry.Unmarshal(resb, req)
+}
@@ -0,0 +1,158 @@
+package tieredcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine"
+	"github.com/Arthur1/http-client-cache/cache/engine/memorycache"
+	mock_engine "github.com/Arthur1/http-client-cache/cache/engine/mock"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type testKeyGenerator struct{}
+
+func (g *testKeyGenerator) Key(_ *http.Request) (string, error) {
+	return "test", nil
+}
+
+func assertBody(t *testing.T, res *http.Response) {
+	t.Helper()
+	resb, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb))
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		e := New([]engine.CacheEngine{memorycache.New()})
+		assert.Nil(t, e.keyGenerator)
+		assert.Equal(t, defaultBackfillTTL, e.backfillTTL)
+	})
+
+	t.Run("WithKeyGenerator and WithBackfillTTL", func(t *testing.T) {
+		t.Parallel()
+		keyGenerator := &testKeyGenerator{}
+		e := New([]engine.CacheEngine{memorycache.New()}, WithKeyGenerator(keyGenerator), WithBackfillTTL(time.Hour))
+		assert.Equal(t, keyGenerator, e.keyGenerator)
+		assert.Equal(t, time.Hour, e.backfillTTL)
+	})
+}
+
+func TestCacheEngineKey(t *testing.T) {
+	t.Parallel()
+	t.Run("Key of the first tier is used by default", func(t *testing.T) {
+		t.Parallel()
+		e := New([]engine.CacheEngine{
+			memorycache.New(memorycache.WithKeyGenerator(&testKeyGenerator{})),
+			memorycache.New(),
+		})
+		got, err := e.Key(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "test", got)
+	})
+
+	t.Run("WithKeyGenerator", func(t *testing.T) {
+		t.Parallel()
+		e := New([]engine.CacheEngine{memorycache.New()}, WithKeyGenerator(&testKeyGenerator{}))
+		got, err := e.Key(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "test", got)
+	})
+}
+
+func TestCacheEngineGetAndSet(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+
+	t.Run("cache miss", func(t *testing.T) {
+		t.Parallel()
+		e := New([]engine.CacheEngine{memorycache.New(), memorycache.New()})
+		_, ok, err := e.Get(ctx, "key1", req)
+		assert.False(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("set writes through to all tiers", func(t *testing.T) {
+		t.Parallel()
+		l1, l2 := memorycache.New(), memorycache.New()
+		e := New([]engine.CacheEngine{l1, l2})
+		err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		assert.NoError(t, err)
+
+		for _, tier := range []engine.CacheEngine{e, l1, l2} {
+			res, ok, err := tier.Get(ctx, "key1", req)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assertBody(t, res)
+		}
+	})
+
+	t.Run("hit in a lower tier is backfilled to upper tiers", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		l1 := mock_engine.NewMockCacheEngine(ctrl)
+		l1.EXPECT().Get(gomock.Any(), "key1", gomock.Any()).Return(nil, false, nil)
+		l1.EXPECT().Set(gomock.Any(), "key1", gomock.Any(), 10*time.Second).Return(nil)
+		l2 := memorycache.New()
+		l2.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+		l3 := mock_engine.NewMockCacheEngine(ctrl)
+		l3.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		l3.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		e := New([]engine.CacheEngine{l1, l2, l3}, WithBackfillTTL(10*time.Second))
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assertBody(t, res)
+	})
+
+	t.Run("failing tiers are regarded as misses", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		l1 := mock_engine.NewMockCacheEngine(ctrl)
+		l1.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, false, errors.New("error")).Times(2)
+		l1.EXPECT().Set(gomock.Any(), "key1", gomock.Any(), gomock.Any()).Return(errors.New("error"))
+		l2 := memorycache.New()
+		l2.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+
+		e := New([]engine.CacheEngine{l1, l2})
+		res, ok, err := e.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assertBody(t, res)
+
+		_, ok, err = e.Get(ctx, "key2", req)
+		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestCacheEngineDelete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	l1, l2 := memorycache.New(), memorycache.New()
+	e := New([]engine.CacheEngine{l1, l2})
+
+	err := e.Set(ctx, "key1", testutil.NewResponse(t, req, "OK\n"), time.Hour)
+	assert.NoError(t, err)
+	err = e.Delete(ctx, "key1")
+	assert.NoError(t, err)
+	for _, tier := range []engine.CacheEngine{l1, l2} {
+		_, ok, err := tier.Get(ctx, "key1", req)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,231 @@
+package entry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Version is the version of the binary format written by MarshalBinary.
+const Version = 1
+
+// magic precedes the version in the binary format. It never begins a response serialized by httputil.DumpResponse.
+var magic = []byte("\x00HCE")
+
+var (
+	ErrMalformed          = errors.New("entry: malformed entry")
+	ErrUnsupportedVersion = errors.New("entry: unsupported version")
+)
+
+// MarshalBinary encodes e into the binary format.
+// The body is encoded last without its length, so that the encoding of e without the body followed by the body
+// is the encoding of e. Cache engines can store the body apart from the rest in this way.
+func (e *Entry) MarshalBinary() ([]byte, error) {
+	w := &writer{buf: make([]byte, 0, 512+len(e.Body))}
+	w.buf = append(w.buf, magic...)
+	w.uvarint(Version)
+	w.string(e.Status)
+	w.uvarint(uint64(e.StatusCode))
+	w.string(e.Proto)
+	w.header(e.Header)
+	w.header(e.Trailer)
+	if err := w.time(e.RequestTime); err != nil {
+		return nil, err
+	}
+	if err := w.time(e.ResponseTime); err != nil {
+		return nil, err
+	}
+	w.varint(int64(e.Lifetime))
+	w.string(e.Request.Method)
+	w.string(e.Request.URL)
+	w.header(e.Request.VariedHeader)
+	w.buf = append(w.buf, e.Body...)
+	return w.buf, nil
+}
+
+// UnmarshalBinary decodes b encoded by MarshalBinary into e.
+func (e *Entry) UnmarshalBinary(b []byte) error {
+	r := &reader{buf: b}
+	if !r.magic() {
+		return ErrMalformed
+	}
+	if version := r.uvarint(); r.err == nil && version != Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	decoded := Entry{
+		Status:       r.string(),
+		StatusCode:   int(r.uvarint()),
+		Proto:        r.string(),
+		Header:       r.header(),
+		Trailer:      r.header(),
+		RequestTime:  r.time(),
+		ResponseTime: r.time(),
+		Lifetime:     time.Duration(r.varint()),
+		Request: Request{
+			Method:       r.string(),
+			URL:          r.string(),
+			VariedHeader: r.header(),
+		},
+	}
+	if r.err != nil {
+		return r.err
+	}
+	decoded.Body = append([]byte(nil), r.buf...)
+	*e = decoded
+	return nil
+}
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *writer) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// header writes the fields of h sorted by name, so that the same header is always encoded into the same bytes.
+func (w *writer) header(h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	w.uvarint(uint64(len(names)))
+	for _, name := range names {
+		w.string(name)
+		w.uvarint(uint64(len(h[name])))
+		for _, value := range h[name] {
+			w.string(value)
+		}
+	}
+}
+
+// time writes t with its location. The zero time is written as empty.
+func (w *writer) time(t time.Time) error {
+	if t.IsZero() {
+		w.bytes(nil)
+		return nil
+	}
+	b, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.bytes(b)
+	return nil
+}
+
+// reader reads the binary format. Once an error occurs, the following reads return zero values.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) magic() bool {
+	if len(r.buf) < len(magic) || string(r.buf[:len(magic)]) != string(magic) {
+		return false
+	}
+	r.buf = r.buf[len(magic):]
+	return true
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = ErrMalformed
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = ErrMalformed
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = ErrMalformed
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) header() http.Header {
+	n := r.uvarint()
+	// each field takes at least two bytes
+	if r.err != nil || n > uint64(len(r.buf)/2) {
+		if r.err == nil {
+			r.err = ErrMalformed
+		}
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	h := make(http.Header, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		name := r.string()
+		count := r.uvarint()
+		if count > uint64(len(r.buf)) {
+			r.err = ErrMalformed
+			break
+		}
+		values := make([]string, 0, count)
+		for j := uint64(0); j < count; j++ {
+			values = append(values, r.string())
+		}
+		h[name] = values
+	}
+	return h
+}
+
+func (r *reader) time() time.Time {
+	b := r.bytes()
+	if r.err != nil || len(b) == 0 {
+		return time.Time{}
+	}
+	var t time.Time
+	if err := t.UnmarshalBinary(b); err != nil {
+		r.err = ErrMalformed
+	}
+	return t
+}
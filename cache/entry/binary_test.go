@@ -0,0 +1,101 @@
+package entry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryMarshalBinary(t *testing.T) {
+	t.Parallel()
+	tokyo := time.FixedZone("Asia/Tokyo", 9*60*60)
+	full := &Entry{
+		Status:       "200 OK",
+		StatusCode:   http.StatusOK,
+		Proto:        "HTTP/2.0",
+		Header:       http.Header{"Content-Type": {"application/octet-stream"}, "Set-Cookie": {"a=1", "b=2"}},
+		Trailer:      http.Header{"X-Checksum": {"abc"}},
+		Body:         []byte{0x00, 0xff, '\r', '\n', '\r', '\n', 0x80},
+		RequestTime:  time.Date(2024, 7, 13, 9, 0, 0, 123, tokyo),
+		ResponseTime: time.Date(2024, 7, 13, 9, 0, 1, 0, tokyo),
+		Lifetime:     -time.Second,
+		Request: Request{
+			Method:       http.MethodPost,
+			URL:          "https://example.com/path?q=1",
+			VariedHeader: http.Header{"Accept-Language": {""}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		entry *Entry
+	}{
+		{name: "All the fields", entry: full},
+		{name: "Zero values", entry: &Entry{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name+" are encoded and decoded", func(t *testing.T) {
+			t.Parallel()
+			b, err := tt.entry.MarshalBinary()
+			assert.NoError(t, err)
+
+			got := &Entry{}
+			assert.NoError(t, got.UnmarshalBinary(b))
+			testutil.NoDiff(t, tt.entry, got, nil)
+			assert.True(t, tt.entry.RequestTime.Equal(got.RequestTime))
+		})
+	}
+
+	t.Run("The encoding is deterministic", func(t *testing.T) {
+		t.Parallel()
+		b1, err := full.MarshalBinary()
+		assert.NoError(t, err)
+		b2, err := full.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Equal(t, b1, b2)
+	})
+
+	t.Run("The encoding without the body followed by the body is the encoding", func(t *testing.T) {
+		t.Parallel()
+		withoutBody := *full
+		withoutBody.Body = nil
+		b, err := withoutBody.MarshalBinary()
+		assert.NoError(t, err)
+		want, err := full.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Equal(t, want, append(b, full.Body...))
+	})
+}
+
+func TestEntryUnmarshalBinary(t *testing.T) {
+	t.Parallel()
+	b, err := (&Entry{StatusCode: http.StatusOK, Header: http.Header{"Content-Length": {"3"}}}).MarshalBinary()
+	assert.NoError(t, err)
+
+	t.Run("Truncated entries are malformed", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < len(b)-1; i++ {
+			err := (&Entry{}).UnmarshalBinary(b[:i])
+			assert.ErrorIs(t, err, ErrMalformed, "length %d", i)
+		}
+	})
+
+	t.Run("Unknown versions are not supported", func(t *testing.T) {
+		t.Parallel()
+		unknown := append([]byte(nil), b...)
+		unknown[len(magic)] = Version + 1
+		err := (&Entry{}).UnmarshalBinary(unknown)
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+
+	t.Run("The entry is not modified on errors", func(t *testing.T) {
+		t.Parallel()
+		e := &Entry{StatusCode: http.StatusNotFound}
+		assert.Error(t, e.UnmarshalBinary(b[:len(b)-2]))
+		assert.Equal(t, http.StatusNotFound, e.StatusCode)
+	})
+}
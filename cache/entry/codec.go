@@ -0,0 +1,147 @@
+package entry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes entries for cache engines.
+type Codec interface {
+	Encode(e *Entry) ([]byte, error)
+	// Decode decodes b into the entry of the response to req. req is needed to decode some formats, such as HTTP dumps of responses to HEAD.
+	Decode(b []byte, req *http.Request) (*Entry, error)
+}
+
+var (
+	_ Codec = BinaryCodec{}
+	_ Codec = DumpCodec{}
+	_ Codec = MsgpackCodec{}
+)
+
+// BinaryCodec encodes entries into the binary format of MarshalBinary, which is the default of cache engines.
+// It decodes HTTP dumps as well.
+type BinaryCodec struct{}
+
+func (BinaryCodec) Encode(e *Entry) ([]byte, error) {
+	return e.MarshalBinary()
+}
+
+func (BinaryCodec) Decode(b []byte, req *http.Request) (*Entry, error) {
+	return Parse(b, req)
+}
+
+// DumpCodec encodes entries into HTTP dumps like httputil.DumpResponse, which are readable for debugging.
+// The metadata is written as header fields, except the method and URL of the request, which are not kept.
+// It decodes the binary format as well, so that cache engines can switch between BinaryCodec and DumpCodec.
+type DumpCodec struct{}
+
+func (DumpCodec) Encode(e *Entry) ([]byte, error) {
+	var req *http.Request
+	if e.Request.Method == http.MethodHead {
+		// the dump of the response to HEAD has Content-Length without the body
+		req = &http.Request{Method: http.MethodHead}
+	}
+	res := e.Response(req)
+	if len(e.Trailer) > 0 {
+		// trailers are written only in the chunked transfer coding
+		res.TransferEncoding = []string{"chunked"}
+		res.ContentLength = -1
+		res.Header.Del("Content-Length")
+	}
+	return httputil.DumpResponse(res, true)
+}
+
+func (DumpCodec) Decode(b []byte, req *http.Request) (*Entry, error) {
+	return Parse(b, req)
+}
+
+// MsgpackCodec encodes entries into MessagePack.
+// It decodes the binary format and HTTP dumps as well.
+type MsgpackCodec struct{}
+
+// msgpackEntry is the MessagePack representation of Entry.
+type msgpackEntry struct {
+	Version      int           `msgpack:"v"`
+	Status       string        `msgpack:"s"`
+	StatusCode   int           `msgpack:"c"`
+	Proto        string        `msgpack:"p"`
+	Header       http.Header   `msgpack:"h"`
+	Trailer      http.Header   `msgpack:"t"`
+	Body         []byte        `msgpack:"b"`
+	RequestTime  time.Time     `msgpack:"rqt"`
+	ResponseTime time.Time     `msgpack:"rst"`
+	Lifetime     time.Duration `msgpack:"l"`
+	Method       string        `msgpack:"m"`
+	URL          string        `msgpack:"u"`
+	VariedHeader http.Header   `msgpack:"vh"`
+}
+
+func (MsgpackCodec) Encode(e *Entry) ([]byte, error) {
+	return msgpack.Marshal(&msgpackEntry{
+		Version:      Version,
+		Status:       e.Status,
+		StatusCode:   e.StatusCode,
+		Proto:        e.Proto,
+		Header:       e.Header,
+		Trailer:      e.Trailer,
+		Body:         e.Body,
+		RequestTime:  e.RequestTime,
+		ResponseTime: e.ResponseTime,
+		Lifetime:     e.Lifetime,
+		Method:       e.Request.Method,
+		URL:          e.Request.URL,
+		VariedHeader: e.Request.VariedHeader,
+	})
+}
+
+func (MsgpackCodec) Decode(b []byte, req *http.Request) (*Entry, error) {
+	if bytes.HasPrefix(b, magic) || bytes.HasPrefix(b, []byte("HTTP/")) {
+		return Parse(b, req)
+	}
+	var m msgpackEntry
+	if err := msgpack.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+	return &Entry{
+		Status:       m.Status,
+		StatusCode:   m.StatusCode,
+		Proto:        m.Proto,
+		Header:       m.Header,
+		Trailer:      m.Trailer,
+		Body:         m.Body,
+		RequestTime:  m.RequestTime,
+		ResponseTime: m.ResponseTime,
+		Lifetime:     m.Lifetime,
+		Request: Request{
+			Method:       m.Method,
+			URL:          m.URL,
+			VariedHeader: m.VariedHeader,
+		},
+	}, nil
+}
+
+// EncodeResponse encodes res with c. The body of res is replaced so that it can be read again.
+func EncodeResponse(c Codec, res *http.Response) ([]byte, error) {
+	e, err := New(res)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encode(e)
+}
+
+// DecodeResponse decodes b encoded by c into the response to req.
+func DecodeResponse(c Codec, b []byte, req *http.Request) (*http.Response, error) {
+	e, err := c.Decode(b, req)
+	if err != nil {
+		return nil, err
+	}
+	return e.Response(req), nil
+}
@@ -0,0 +1,162 @@
+package entry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+	codecs := []struct {
+		name  string
+		codec Codec
+	}{
+		{name: "BinaryCodec", codec: BinaryCodec{}},
+		{name: "DumpCodec", codec: DumpCodec{}},
+		{name: "MsgpackCodec", codec: MsgpackCodec{}},
+	}
+	binaryPayload := []byte{0x00, 0xff, 0xfe, '\r', '\n', '\r', '\n', 0x80, 0x81, 'H', 'T', 'T', 'P', '/'}
+	responses := []struct {
+		name        string
+		method      string
+		serialized  string
+		wantBody    []byte
+		wantTrailer http.Header
+		wantLength  int64
+	}{
+		{
+			name:       "a response with Content-Length",
+			method:     http.MethodGet,
+			serialized: "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nEtag: \"abc\"\r\n\r\nOK\n",
+			wantBody:   []byte("OK\n"),
+			wantLength: 3,
+		},
+		{
+			name:       "a chunked body",
+			method:     http.MethodGet,
+			serialized: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nHello\r\n8\r\n, World!\r\n0\r\n\r\n",
+			wantBody:   []byte("Hello, World!"),
+			wantLength: 13,
+		},
+		{
+			name:   "trailers",
+			method: http.MethodGet,
+			serialized: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum, X-Count\r\n\r\n" +
+				"3\r\nOK\n\r\n0\r\nX-Checksum: abc\r\nX-Count: 1\r\n\r\n",
+			wantBody:    []byte("OK\n"),
+			wantTrailer: http.Header{"X-Checksum": {"abc"}, "X-Count": {"1"}},
+			wantLength:  -1,
+		},
+		{
+			name:       "a binary payload",
+			method:     http.MethodGet,
+			serialized: "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: 14\r\n\r\n" + string(binaryPayload),
+			wantBody:   binaryPayload,
+			wantLength: 14,
+		},
+		{
+			name:       "an empty body",
+			method:     http.MethodGet,
+			serialized: "HTTP/1.1 204 No Content\r\n\r\n",
+			wantBody:   []byte{},
+			wantLength: 0,
+		},
+		{
+			name:       "a response to HEAD",
+			method:     http.MethodHead,
+			serialized: "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n",
+			wantBody:   []byte{},
+			wantLength: 100,
+		},
+	}
+	for _, c := range codecs {
+		c := c
+		for _, r := range responses {
+			r := r
+			t.Run(c.name+" round-trips "+r.name, func(t *testing.T) {
+				t.Parallel()
+				req, _ := http.NewRequest(r.method, "https://example.com", nil)
+				res := newResponse(t, req, r.serialized)
+				res.Header.Set(HeaderResponseTime, "2024-07-13T00:00:01Z")
+
+				b, err := EncodeResponse(c.codec, res)
+				assert.NoError(t, err)
+				got, err := DecodeResponse(c.codec, b, req)
+				assert.NoError(t, err)
+
+				assert.Equal(t, res.StatusCode, got.StatusCode)
+				assert.Equal(t, res.Status, got.Status)
+				for _, name := range []string{"Etag", "Content-Type", HeaderResponseTime} {
+					assert.Equal(t, res.Header.Values(name), got.Header.Values(name), name)
+				}
+				body, err := io.ReadAll(got.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, r.wantBody, body)
+				if r.wantLength >= 0 {
+					assert.Equal(t, r.wantLength, got.ContentLength)
+				}
+				assert.Equal(t, r.wantTrailer, got.Trailer)
+			})
+		}
+	}
+
+	t.Run("BinaryCodec and MsgpackCodec keep the request metadata", func(t *testing.T) {
+		t.Parallel()
+		e := &Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Vary": {"Accept-Language"}},
+			Body:       []byte("OK\n"),
+			Request:    Request{Method: http.MethodGet, URL: "https://example.com", VariedHeader: http.Header{"Accept-Language": {"ja"}}},
+		}
+		for _, codec := range []Codec{BinaryCodec{}, MsgpackCodec{}} {
+			b, err := codec.Encode(e)
+			assert.NoError(t, err)
+			got, err := codec.Decode(b, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, e.Request, got.Request)
+		}
+	})
+
+	t.Run("DumpCodec encodes readable HTTP dumps", func(t *testing.T) {
+		t.Parallel()
+		res := newResponse(t, nil, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nX-Http-Client-Cache-Lifetime: 1m0s\r\n\r\nOK\n")
+
+		b, err := EncodeResponse(DumpCodec{}, res)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nX-Http-Client-Cache-Lifetime: 1m0s\r\n\r\nOK\n", string(b))
+	})
+
+	t.Run("Each codec decodes the binary format and HTTP dumps", func(t *testing.T) {
+		t.Parallel()
+		e := &Entry{Status: "200 OK", StatusCode: http.StatusOK, Proto: "HTTP/1.1", Header: http.Header{"Content-Length": {"3"}}, Body: []byte("OK\n")}
+		binary, err := BinaryCodec{}.Encode(e)
+		assert.NoError(t, err)
+		dump, err := DumpCodec{}.Encode(e)
+		assert.NoError(t, err)
+
+		for _, c := range codecs {
+			for _, b := range [][]byte{binary, dump} {
+				got, err := c.codec.Decode(b, nil)
+				assert.NoError(t, err, c.name)
+				assert.Equal(t, []byte("OK\n"), got.Body, c.name)
+			}
+		}
+	})
+
+	t.Run("MsgpackCodec rejects unknown versions", func(t *testing.T) {
+		t.Parallel()
+		b, err := MsgpackCodec{}.Encode(&Entry{})
+		assert.NoError(t, err)
+		// the version is encoded first as {"v": 1}
+		i := bytes.Index(b, []byte{0xa1, 'v', Version})
+		assert.GreaterOrEqual(t, i, 0)
+		b[i+2] = Version + 1
+
+		_, err = MsgpackCodec{}.Decode(b, nil)
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+}
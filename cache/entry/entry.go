@@ -0,0 +1,200 @@
+// Package entry defines the format in which cache engines store responses.
+package entry
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header fields which Transport adds to responses to carry cache metadata through a CacheEngine.
+// They are lifted into the fields of Entry when stored, and restored when read.
+const (
+	MetadataHeaderPrefix = "X-Http-Client-Cache-"
+	HeaderRequestTime    = MetadataHeaderPrefix + "Request-Time"
+	HeaderResponseTime   = MetadataHeaderPrefix + "Response-Time"
+	// freshness lifetime overridden by Policy.TTL
+	HeaderLifetime = MetadataHeaderPrefix + "Lifetime"
+	// followed by the name of a request header field nominated by Vary
+	HeaderVariedPrefix = MetadataHeaderPrefix + "Varied-"
+)
+
+// Entry is a stored response with its metadata.
+type Entry struct {
+	Status     string
+	StatusCode int
+	Proto      string
+	// Header excludes the metadata header fields, which are held by the other fields.
+	Header  http.Header
+	Trailer http.Header
+	Body    []byte
+	// times at which the response was requested and received, which are zero if unknown
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// freshness lifetime overriding the one computed from the response, which is zero if not overridden
+	Lifetime time.Duration
+	Request  Request
+}
+
+// Request is the metadata of the request to which the response was returned.
+type Request struct {
+	Method string
+	URL    string
+	// values of the request header fields nominated by Vary
+	VariedHeader http.Header
+}
+
+// New returns the entry of res, reading its body. The body of res is replaced so that it can be read again.
+func New(res *http.Response) (*Entry, error) {
+	var body []byte
+	if res.Body != nil && res.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	e := &Entry{
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+		Proto:      res.Proto,
+		Header:     http.Header{},
+		Trailer:    res.Trailer.Clone(),
+		Body:       body,
+	}
+	for name, values := range res.Header {
+		if !e.lift(name, values) {
+			e.Header[name] = append([]string(nil), values...)
+		}
+	}
+	if req := res.Request; req != nil {
+		e.Request.Method = req.Method
+		if req.URL != nil {
+			e.Request.URL = req.URL.String()
+		}
+	}
+	return e, nil
+}
+
+// lift sets the metadata header field to the field of e, and reports whether it is lifted.
+// Unknown or malformed metadata header fields are left in the header.
+func (e *Entry) lift(name string, values []string) bool {
+	if !strings.HasPrefix(name, MetadataHeaderPrefix) || len(values) == 0 {
+		return false
+	}
+	var err error
+	switch {
+	case name == HeaderRequestTime:
+		e.RequestTime, err = time.Parse(time.RFC3339Nano, values[0])
+	case name == HeaderResponseTime:
+		e.ResponseTime, err = time.Parse(time.RFC3339Nano, values[0])
+	case name == HeaderLifetime:
+		e.Lifetime, err = time.ParseDuration(values[0])
+	case strings.HasPrefix(name, HeaderVariedPrefix):
+		if e.Request.VariedHeader == nil {
+			e.Request.VariedHeader = http.Header{}
+		}
+		e.Request.VariedHeader.Set(strings.TrimPrefix(name, HeaderVariedPrefix), values[0])
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// Response returns the response of e to req, with the metadata header fields restored.
+func (e *Entry) Response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if !e.RequestTime.IsZero() {
+		header.Set(HeaderRequestTime, e.RequestTime.UTC().Format(time.RFC3339Nano))
+	}
+	if !e.ResponseTime.IsZero() {
+		header.Set(HeaderResponseTime, e.ResponseTime.UTC().Format(time.RFC3339Nano))
+	}
+	if e.Lifetime != 0 {
+		header.Set(HeaderLifetime, e.Lifetime.String())
+	}
+	for name, values := range e.Request.VariedHeader {
+		header[HeaderVariedPrefix+name] = append([]string(nil), values...)
+	}
+
+	major, minor, ok := http.ParseHTTPVersion(e.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+	}
+	contentLength := int64(len(e.Body))
+	if req != nil && req.Method == http.MethodHead {
+		// the response to HEAD has no body, but Content-Length of the response to GET
+		contentLength = -1
+		if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			contentLength = n
+		}
+	}
+	return &http.Response{
+		Status:        status,
+		StatusCode:    e.StatusCode,
+		Proto:         e.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Trailer:       e.Trailer.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: contentLength,
+		Request:       req,
+	}
+}
+
+// Parse decodes b encoded by MarshalBinary.
+// It also accepts a response serialized by httputil.DumpResponse, in which cache engines stored responses formerly.
+func Parse(b []byte, req *http.Request) (*Entry, error) {
+	if !bytes.HasPrefix(b, magic) {
+		return parseDump(b, req)
+	}
+	e := &Entry{}
+	if err := e.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseDump decodes the response to req serialized by httputil.DumpResponse.
+func parseDump(b []byte, req *http.Request) (*Entry, error) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		return nil, err
+	}
+	// the dump does not record the request
+	res.Request = nil
+	return New(res)
+}
+
+// Marshal encodes res into the binary format.
+func Marshal(res *http.Response) ([]byte, error) {
+	e, err := New(res)
+	if err != nil {
+		return nil, err
+	}
+	return e.MarshalBinary()
+}
+
+// Unmarshal decodes b encoded by Marshal, or serialized by httputil.DumpResponse, into the response to req.
+func Unmarshal(b []byte, req *http.Request) (*http.Response, error) {
+	e, err := Parse(b, req)
+	if err != nil {
+		return nil, err
+	}
+	return e.Response(req), nil
+}
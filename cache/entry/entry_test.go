@@ -0,0 +1,207 @@
+package entry
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newResponse(t *testing.T, req *http.Request, serialized string) *http.Response {
+	t.Helper()
+	res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(serialized)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	t.Run("The metadata header fields are lifted into the fields", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/path?q=1", nil)
+		res := newResponse(t, req, "HTTP/1.1 200 OK\r\n"+
+			"Content-Length: 3\r\n"+
+			"Etag: \"abc\"\r\n"+
+			"Vary: Accept-Language\r\n"+
+			"X-Http-Client-Cache-Request-Time: 2024-07-13T00:00:00Z\r\n"+
+			"X-Http-Client-Cache-Response-Time: 2024-07-13T00:00:01.5Z\r\n"+
+			"X-Http-Client-Cache-Lifetime: 1h0m0s\r\n"+
+			"X-Http-Client-Cache-Varied-Accept-Language: ja\r\n"+
+			"X-Http-Client-Cache-Unknown: value\r\n"+
+			"\r\nOK\n")
+
+		got, err := New(res)
+		assert.NoError(t, err)
+		want := &Entry{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			Header: http.Header{
+				"Content-Length":              {"3"},
+				"Etag":                        {`"abc"`},
+				"Vary":                        {"Accept-Language"},
+				"X-Http-Client-Cache-Unknown": {"value"},
+			},
+			Body:         []byte("OK\n"),
+			RequestTime:  time.Date(2024, 7, 13, 0, 0, 0, 0, time.UTC),
+			ResponseTime: time.Date(2024, 7, 13, 0, 0, 1, 500_000_000, time.UTC),
+			Lifetime:     time.Hour,
+			Request: Request{
+				Method:       http.MethodGet,
+				URL:          "https://example.com/path?q=1",
+				VariedHeader: http.Header{"Accept-Language": {"ja"}},
+			},
+		}
+		testutil.NoDiff(t, want, got, nil)
+
+		// the body can be read again
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, "ja", res.Header.Get(HeaderVariedPrefix+"Accept-Language"))
+	})
+
+	t.Run("Malformed metadata header fields are left in the header", func(t *testing.T) {
+		t.Parallel()
+		res := newResponse(t, nil, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nX-Http-Client-Cache-Request-Time: yesterday\r\n\r\n")
+
+		got, err := New(res)
+		assert.NoError(t, err)
+		assert.True(t, got.RequestTime.IsZero())
+		assert.Equal(t, "yesterday", got.Header.Get(HeaderRequestTime))
+	})
+
+	t.Run("Trailers are kept", func(t *testing.T) {
+		t.Parallel()
+		res := newResponse(t, nil, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"+
+			"3\r\nOK\n\r\n0\r\nX-Checksum: abc\r\n\r\n")
+
+		got, err := New(res)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("OK\n"), got.Body)
+		assert.Equal(t, http.Header{"X-Checksum": {"abc"}}, got.Trailer)
+	})
+}
+
+func TestEntryResponse(t *testing.T) {
+	t.Parallel()
+	t.Run("The metadata header fields are restored", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		e := &Entry{
+			Status:       "200 OK",
+			StatusCode:   http.StatusOK,
+			Proto:        "HTTP/1.1",
+			Header:       http.Header{"Content-Length": {"3"}},
+			Trailer:      http.Header{"X-Checksum": {"abc"}},
+			Body:         []byte("OK\n"),
+			RequestTime:  time.Date(2024, 7, 13, 0, 0, 0, 0, time.UTC),
+			ResponseTime: time.Date(2024, 7, 13, 0, 0, 1, 500_000_000, time.UTC),
+			Lifetime:     time.Hour,
+			Request:      Request{VariedHeader: http.Header{"Accept-Language": {"ja"}}},
+		}
+
+		res := e.Response(req)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, 1, res.ProtoMajor)
+		assert.Equal(t, 1, res.ProtoMinor)
+		assert.Equal(t, int64(3), res.ContentLength)
+		assert.Equal(t, req, res.Request)
+		testutil.NoDiff(t, http.Header{
+			"Content-Length":                             {"3"},
+			"X-Http-Client-Cache-Request-Time":           {"2024-07-13T00:00:00Z"},
+			"X-Http-Client-Cache-Response-Time":          {"2024-07-13T00:00:01.5Z"},
+			"X-Http-Client-Cache-Lifetime":               {"1h0m0s"},
+			"X-Http-Client-Cache-Varied-Accept-Language": {"ja"},
+		}, res.Header, nil)
+		assert.Equal(t, http.Header{"X-Checksum": {"abc"}}, res.Trailer)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+
+		// the entry is not modified by the response
+		res.Header.Set("Content-Length", "0")
+		assert.Equal(t, "3", e.Header.Get("Content-Length"))
+	})
+
+	t.Run("The response to HEAD has Content-Length of the header", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodHead, "https://example.com", nil)
+		e := &Entry{StatusCode: http.StatusOK, Proto: "HTTP/1.1", Header: http.Header{"Content-Length": {"100"}}}
+
+		res := e.Response(req)
+		assert.Equal(t, "200 OK", res.Status)
+		assert.Equal(t, int64(100), res.ContentLength)
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	t.Run("The binary format is decoded", func(t *testing.T) {
+		t.Parallel()
+		want := &Entry{Status: "200 OK", StatusCode: http.StatusOK, Proto: "HTTP/1.1", Header: http.Header{"Content-Length": {"3"}}, Body: []byte("OK\n")}
+		b, err := want.MarshalBinary()
+		assert.NoError(t, err)
+
+		got, err := Parse(b, nil)
+		assert.NoError(t, err)
+		testutil.NoDiff(t, want, got, nil)
+	})
+
+	t.Run("The legacy format dumped by httputil.DumpResponse is decoded", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		dump := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nX-Http-Client-Cache-Response-Time: 2024-07-13T00:00:01Z\r\n\r\nOK\n"
+
+		got, err := Parse([]byte(dump), req)
+		assert.NoError(t, err)
+		want := &Entry{
+			Status:       "200 OK",
+			StatusCode:   http.StatusOK,
+			Proto:        "HTTP/1.1",
+			Header:       http.Header{"Content-Length": {"3"}},
+			Body:         []byte("OK\n"),
+			ResponseTime: time.Date(2024, 7, 13, 0, 0, 1, 0, time.UTC),
+		}
+		testutil.NoDiff(t, want, got, nil)
+	})
+
+	t.Run("The legacy format of the response to HEAD is decoded", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodHead, "https://example.com", nil)
+
+		res, err := Unmarshal([]byte("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"), req)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(100), res.ContentLength)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Empty(t, resb)
+	})
+}
+
+func TestMarshalAndUnmarshal(t *testing.T) {
+	t.Parallel()
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	res := newResponse(t, req, "HTTP/1.1 404 Not Found\r\nContent-Length: 3\r\nX-Http-Client-Cache-Lifetime: 1m0s\r\n\r\nNG\n")
+
+	b, err := Marshal(res)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(b, magic))
+
+	got, err := Unmarshal(b, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, got.StatusCode)
+	assert.Equal(t, "404 Not Found", got.Status)
+	assert.Equal(t, "1m0s", got.Header.Get(HeaderLifetime))
+	resb, err := io.ReadAll(got.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "NG\n", string(resb))
+}
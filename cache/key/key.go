@@ -2,6 +2,7 @@ package key
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"hash/fnv"
 	"io"
@@ -22,6 +23,20 @@ func NewKeyGenerator(partitionKey string) *DefaultKeyGenerator {
 	}
 }
 
+type partitionKeyContextKey struct{}
+
+// ContextWithPartitionKey returns a copy of ctx with the partition key,
+// which separates the cache space within DefaultKeyGenerator.PartitionKey for requests made with it.
+func ContextWithPartitionKey(ctx context.Context, partitionKey string) context.Context {
+	return context.WithValue(ctx, partitionKeyContextKey{}, partitionKey)
+}
+
+// PartitionKeyFromContext returns the partition key attached to ctx.
+func PartitionKeyFromContext(ctx context.Context) (string, bool) {
+	partitionKey, ok := ctx.Value(partitionKeyContextKey{}).(string)
+	return partitionKey, ok
+}
+
 func (g *DefaultKeyGenerator) Key(req *http.Request) (string, error) {
 	var (
 		body []byte
@@ -35,9 +50,14 @@ func (g *DefaultKeyGenerator) Key(req *http.Request) (string, error) {
 		req.Body = io.NopCloser(bytes.NewReader(body))
 	}
 
+	partitionKey := g.PartitionKey
+	if pk, ok := PartitionKeyFromContext(req.Context()); ok {
+		partitionKey += "/" + pk
+	}
+
 	h := fnv.New64a()
 	h.Write([]byte(req.Method))
 	h.Write([]byte(req.URL.String()))
 	h.Write(body)
-	return fmt.Sprintf("%s_%x", g.PartitionKey, h.Sum64()), nil
+	return fmt.Sprintf("%s_%x", partitionKey, h.Sum64()), nil
 }
@@ -1,6 +1,7 @@
 package key
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"strings"
@@ -117,4 +118,23 @@ func TestDefaultKeyGeneratorKey(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "A", string(body))
 	})
+
+	t.Run("Partition key in the context separates the cache space within the partition key of the generator", func(t *testing.T) {
+		t.Parallel()
+		g1 := &DefaultKeyGenerator{PartitionKey: "serviceA"}
+		g2 := &DefaultKeyGenerator{PartitionKey: "serviceB"}
+		req1, _ := http.NewRequestWithContext(ContextWithPartitionKey(context.Background(), "user1"), http.MethodGet, "http://example.com", nil)
+		req2, _ := http.NewRequestWithContext(ContextWithPartitionKey(context.Background(), "user2"), http.MethodGet, "http://example.com", nil)
+		req3, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+		keys := map[string]struct{}{}
+		for _, g := range []*DefaultKeyGenerator{g1, g2} {
+			for _, req := range []*http.Request{req1, req2, req3} {
+				got, err := g.Key(req)
+				assert.NoError(t, err)
+				keys[got] = struct{}{}
+			}
+		}
+		assert.Len(t, keys, 6)
+	})
 }
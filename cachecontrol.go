@@ -0,0 +1,79 @@
+package httpclientcache
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 9111 Section 1.2.2: delta-seconds greater than 2^31 are treated as 2^31.
+const maxDeltaSeconds = 1 << 31
+
+// cacheControl holds parsed Cache-Control directives. Directive names are lower-cased.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			if _, ok := cc[name]; ok {
+				// RFC 9111 Section 4.2.1: use the first occurrence of a duplicated directive.
+				continue
+			}
+			cc[name] = value
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta-seconds value of the directive.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		if !errors.Is(err, strconv.ErrRange) || strings.HasPrefix(value, "-") {
+			return 0, false
+		}
+		n = maxDeltaSeconds
+	}
+	if n < 0 {
+		return 0, false
+	}
+	if n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// parseRequestCacheControl parses the Cache-Control directives of a request.
+// Pragma: no-cache is regarded as Cache-Control: no-cache if Cache-Control is absent (RFC 9111 Section 5.4).
+func parseRequestCacheControl(h http.Header) cacheControl {
+	cc := parseCacheControl(h)
+	if len(h.Values("Cache-Control")) == 0 {
+		for _, line := range h.Values("Pragma") {
+			for _, part := range strings.Split(line, ",") {
+				if strings.EqualFold(strings.TrimSpace(part), "no-cache") {
+					cc["no-cache"] = ""
+				}
+			}
+		}
+	}
+	return cc
+}
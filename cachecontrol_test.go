@@ -0,0 +1,78 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	t.Parallel()
+	t.Run("Directives are lower-cased and quoted values are unquoted", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{}
+		h.Add("Cache-Control", `Public, MAX-AGE=60, private="Set-Cookie"`)
+		h.Add("Cache-Control", "no-transform")
+		want := cacheControl{
+			"public":       "",
+			"max-age":      "60",
+			"private":      "Set-Cookie",
+			"no-transform": "",
+		}
+		testutil.NoDiff(t, want, parseCacheControl(h), nil)
+	})
+
+	t.Run("First occurrence of a duplicated directive is used", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{}
+		h.Set("Cache-Control", "max-age=60, max-age=10")
+		assert.Equal(t, "60", parseCacheControl(h)["max-age"])
+	})
+
+	t.Run("No Cache-Control header", func(t *testing.T) {
+		t.Parallel()
+		assert.Empty(t, parseCacheControl(http.Header{}))
+	})
+}
+
+func TestCacheControlSeconds(t *testing.T) {
+	t.Parallel()
+	h := http.Header{}
+	h.Set("Cache-Control", "max-age=60, s-maxage=abc, max-stale=-1, min-fresh=99999999999999999999")
+	cc := parseCacheControl(h)
+
+	got, ok := cc.seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, got)
+
+	_, ok = cc.seconds("s-maxage")
+	assert.False(t, ok)
+
+	_, ok = cc.seconds("max-stale")
+	assert.False(t, ok)
+
+	got, ok = cc.seconds("min-fresh")
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(maxDeltaSeconds)*time.Second, got)
+
+	_, ok = cc.seconds("no-cache")
+	assert.False(t, ok)
+}
+
+func TestParseRequestCacheControl(t *testing.T) {
+	t.Parallel()
+	t.Run("Pragma: no-cache is regarded as Cache-Control: no-cache", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{"Pragma": {"no-cache"}}
+		assert.True(t, parseRequestCacheControl(h).has("no-cache"))
+	})
+
+	t.Run("Pragma is ignored if Cache-Control is present", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=60"}}
+		assert.False(t, parseRequestCacheControl(h).has("no-cache"))
+	})
+}
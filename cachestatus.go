@@ -0,0 +1,68 @@
+package httpclientcache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cacheStatusName is the identifier of this cache in the Cache-Status header.
+const cacheStatusName = "http-client-cache"
+
+// cacheStatus describes how the cache handled a request (RFC 9211).
+type cacheStatus struct {
+	hit       bool
+	fwd       string
+	fwdStatus int
+	stored    bool
+	collapsed bool
+	ttl       *time.Duration
+	key       string
+	detail    string
+}
+
+func (s cacheStatus) String() string {
+	params := []string{cacheStatusName}
+	if s.hit {
+		params = append(params, "hit")
+	}
+	if s.fwd != "" {
+		params = append(params, "fwd="+s.fwd)
+	}
+	if s.fwdStatus != 0 {
+		params = append(params, fmt.Sprintf("fwd-status=%d", s.fwdStatus))
+	}
+	if s.stored {
+		params = append(params, "stored")
+	}
+	if s.collapsed {
+		params = append(params, "collapsed")
+	}
+	if s.ttl != nil {
+		params = append(params, fmt.Sprintf("ttl=%d", int64(*s.ttl/time.Second)))
+	}
+	if s.key != "" {
+		params = append(params, "key="+quoteString(s.key))
+	}
+	if s.detail != "" {
+		params = append(params, "detail="+quoteString(s.detail))
+	}
+	return strings.Join(params, "; ")
+}
+
+// quoteString serializes s as a String of Structured Field Values (RFC 8941 Section 3.3.3).
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			continue
+		}
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
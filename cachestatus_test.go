@@ -0,0 +1,47 @@
+package httpclientcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStatusString(t *testing.T) {
+	t.Parallel()
+	ttl := 30*time.Second + 500*time.Millisecond
+	negativeTTL := -10 * time.Second
+	tests := []struct {
+		name   string
+		status cacheStatus
+		want   string
+	}{
+		{
+			name:   "hit",
+			status: cacheStatus{hit: true, ttl: &ttl, key: "key"},
+			want:   `http-client-cache; hit; ttl=30; key="key"`,
+		},
+		{
+			name:   "stale hit",
+			status: cacheStatus{hit: true, ttl: &negativeTTL},
+			want:   `http-client-cache; hit; ttl=-10`,
+		},
+		{
+			name:   "miss",
+			status: cacheStatus{fwd: "uri-miss", fwdStatus: 200, stored: true, collapsed: true, key: "key"},
+			want:   `http-client-cache; fwd=uri-miss; fwd-status=200; stored; collapsed; key="key"`,
+		},
+		{
+			name:   "detail",
+			status: cacheStatus{detail: `only-if-cached "\`},
+			want:   `http-client-cache; detail="only-if-cached \"\\"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.status.String())
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package httpclientcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/key"
+)
+
+// Policy controls the cache for an individual request.
+// It is attached to the context of a request by ContextWithPolicy.
+type Policy struct {
+	// Bypass sends the request to the origin without looking up or storing to the cache.
+	Bypass bool
+	// Refresh retrieves the response from the origin without looking up the cache, and stores it.
+	Refresh bool
+	// TTL overrides the freshness lifetime of the response to be stored, if positive.
+	TTL time.Duration
+	// PartitionKey separates the cache space within the partition key of key.DefaultKeyGenerator, if not empty.
+	PartitionKey string
+}
+
+type policyContextKey struct{}
+
+// ContextWithPolicy returns a copy of ctx with the cache policy for requests made with it.
+func ContextWithPolicy(ctx context.Context, policy Policy) context.Context {
+	if policy.PartitionKey != "" {
+		ctx = key.ContextWithPartitionKey(ctx, policy.PartitionKey)
+	}
+	return context.WithValue(ctx, policyContextKey{}, policy)
+}
+
+// PolicyFromContext returns the cache policy attached to ctx.
+func PolicyFromContext(ctx context.Context) (Policy, bool) {
+	policy, ok := ctx.Value(policyContextKey{}).(Policy)
+	return policy, ok
+}
@@ -0,0 +1,34 @@
+package httpclientcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/key"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithPolicy(t *testing.T) {
+	t.Parallel()
+	t.Run("Policy is attached to the context", func(t *testing.T) {
+		t.Parallel()
+		policy := Policy{Refresh: true, TTL: time.Hour, PartitionKey: "user1"}
+		ctx := ContextWithPolicy(context.Background(), policy)
+
+		got, ok := PolicyFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, policy, got)
+		partitionKey, ok := key.PartitionKeyFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "user1", partitionKey)
+	})
+
+	t.Run("No policy", func(t *testing.T) {
+		t.Parallel()
+		_, ok := PolicyFromContext(context.Background())
+		assert.False(t, ok)
+		_, ok = key.PartitionKeyFromContext(ContextWithPolicy(context.Background(), Policy{}))
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,56 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// freshnessLifetime computes the freshness lifetime of a response (RFC 9111 Section 4.2.1).
+// If the response does not specify explicit expiration time, fallback is used instead of heuristic freshness.
+// s-maxage is applied only to shared caches.
+func freshnessLifetime(h http.Header, now time.Time, fallback time.Duration, shared bool) time.Duration {
+	cc := parseCacheControl(h)
+	if lifetime, ok := cc.seconds("s-maxage"); ok && shared {
+		return lifetime
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+	if _, ok := h["Expires"]; ok {
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			// invalid dates like "0" represent a time in the past
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return max(0, expires.Sub(date))
+	}
+	return fallback
+}
+
+// initialAge estimates the age of a response at the time it was received (RFC 9111 Section 4.2.3).
+func initialAge(h http.Header, requestTime, responseTime time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		apparentAge = max(0, responseTime.Sub(date).Truncate(time.Second))
+	}
+	var correctedAgeValue time.Duration
+	if age, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && age > 0 {
+		responseDelay := max(0, responseTime.Sub(requestTime))
+		correctedAgeValue = time.Duration(min(age, maxDeltaSeconds))*time.Second + responseDelay
+	}
+	return max(apparentAge, correctedAgeValue)
+}
+
+// currentAge returns the age of a stored response at now, and false if the response carries no metadata.
+func currentAge(h http.Header, now time.Time) (time.Duration, bool) {
+	requestTime, responseTime, ok := responseTimes(h)
+	if !ok {
+		return 0, false
+	}
+	return initialAge(h, requestTime, responseTime) + max(0, now.Sub(responseTime)), true
+}
@@ -0,0 +1,128 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreshnessLifetime(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	fallback := 5 * time.Minute
+
+	tests := []struct {
+		name   string
+		header http.Header
+		shared bool
+		want   time.Duration
+	}{
+		{
+			name:   "s-maxage takes precedence over max-age in shared caches",
+			header: http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}},
+			shared: true,
+			want:   120 * time.Second,
+		},
+		{
+			name:   "s-maxage is ignored in private caches",
+			header: http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}},
+			shared: false,
+			want:   60 * time.Second,
+		},
+		{
+			name: "max-age takes precedence over Expires",
+			header: http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Expires":       {now.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			want: 60 * time.Second,
+		},
+		{
+			name: "Expires is relative to Date",
+			header: http.Header{
+				"Date":    {now.Add(-time.Hour).Format(http.TimeFormat)},
+				"Expires": {now.Format(http.TimeFormat)},
+			},
+			want: time.Hour,
+		},
+		{
+			name:   "Expires without Date is relative to now",
+			header: http.Header{"Expires": {now.Add(30 * time.Second).Format(http.TimeFormat)}},
+			want:   30 * time.Second,
+		},
+		{
+			name:   "Invalid Expires means already expired",
+			header: http.Header{"Expires": {"0"}},
+			want:   0,
+		},
+		{
+			name:   "Fallback if no explicit expiration",
+			header: http.Header{"Cache-Control": {"public"}},
+			want:   fallback,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, freshnessLifetime(tt.header, now, fallback, tt.shared))
+		})
+	}
+}
+
+func TestInitialAge(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Apparent age from Date", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{"Date": {now.Add(-10 * time.Second).Format(http.TimeFormat)}}
+		assert.Equal(t, 10*time.Second, initialAge(h, now, now))
+	})
+
+	t.Run("Age header is used if greater than apparent age", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{
+			"Date": {now.Add(-10 * time.Second).Format(http.TimeFormat)},
+			"Age":  {"30"},
+		}
+		assert.Equal(t, 30*time.Second, initialAge(h, now, now))
+	})
+
+	t.Run("Age header is corrected by response delay", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{"Age": {"30"}}
+		assert.Equal(t, 32*time.Second, initialAge(h, now.Add(-2*time.Second), now))
+	})
+
+	t.Run("Date in the future is treated as zero", func(t *testing.T) {
+		t.Parallel()
+		h := http.Header{"Date": {now.Add(10 * time.Second).Format(http.TimeFormat)}}
+		assert.Equal(t, time.Duration(0), initialAge(h, now, now))
+	})
+}
+
+func TestCurrentAge(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Resident time is added to initial age", func(t *testing.T) {
+		t.Parallel()
+		responseTime := now.Add(-time.Minute)
+		h := http.Header{
+			"Date": {responseTime.Add(-10 * time.Second).Format(http.TimeFormat)},
+		}
+		setResponseTimes(h, responseTime, responseTime)
+		got, ok := currentAge(h, now)
+		assert.True(t, ok)
+		assert.Equal(t, 70*time.Second, got)
+	})
+
+	t.Run("Unknown if the response has no metadata", func(t *testing.T) {
+		t.Parallel()
+		_, ok := currentAge(http.Header{}, now)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,20 @@
+package testutil
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// NewResponse returns a response of 200 OK to req with body.
+func NewResponse(t *testing.T, req *http.Request, body string) *http.Response {
+	t.Helper()
+	serializedResMock := "HTTP/1.1 200 OK\nContent-Length: " + strconv.Itoa(len(body)) + "\n\n" + body
+	res, err := http.ReadResponse(bufio.NewReader(strings.NewReader(serializedResMock)), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package httpclientcache
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// invalidate deletes the stored responses for the target URI of an unsafe request,
+// and for the URIs in the Location and Content-Location header fields of its response (RFC 9111 Section 4.4).
+// Variants selected by Vary are invalidated together: deleting the primary entry renews the time recorded by the next primary entry,
+// before which the secondary entries are ignored (see Transport.set).
+func (t *Transport) invalidate(ctx context.Context, req *http.Request, res *http.Response) {
+	if safeMethod(req.Method) || res.StatusCode < 200 || res.StatusCode >= 400 {
+		return
+	}
+	targets := []*url.URL{req.URL}
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := res.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		target, err := req.URL.Parse(value)
+		if err != nil {
+			continue
+		}
+		// URIs with a different origin are not invalidated to prevent denial-of-service attacks.
+		if target.Scheme != req.URL.Scheme || target.Host != req.URL.Host {
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	for _, target := range targets {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			targetReq, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+			if err != nil {
+				continue
+			}
+			targetReq.Header = req.Header.Clone()
+			key, err := t.cacheEngine.Key(targetReq)
+			if err != nil {
+				t.logger.ErrorContext(ctx, "failed to generate cache key to invalidate", slog.Any("error", err))
+				continue
+			}
+			if err := t.cacheEngine.Delete(ctx, key); err != nil {
+				t.logger.ErrorContext(ctx, "failed to invalidate cache", slog.Any("error", err))
+			}
+		}
+	}
+}
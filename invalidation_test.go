@@ -0,0 +1,130 @@
+package httpclientcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine/memorycache"
+	mock_engine "github.com/Arthur1/http-client-cache/cache/engine/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSafeMethod(t *testing.T) {
+	t.Parallel()
+	assert.True(t, safeMethod(http.MethodGet))
+	assert.True(t, safeMethod(http.MethodHead))
+	assert.False(t, safeMethod(http.MethodPost))
+	assert.False(t, safeMethod(http.MethodPut))
+	assert.False(t, safeMethod(http.MethodPatch))
+	assert.False(t, safeMethod(http.MethodDelete))
+}
+
+func TestTransportInvalidate(t *testing.T) {
+	t.Parallel()
+	newCacheEngineMock := func(ctrl *gomock.Controller) *mock_engine.MockCacheEngine {
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).DoAndReturn(func(req *http.Request) (string, error) {
+			return req.Method + " " + req.URL.String(), nil
+		}).AnyTimes()
+		return cacheEngineMock
+	}
+
+	t.Run("Unsafe method invalidates the target URI and Location / Content-Location of the same origin", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(ctrl)
+		for _, key := range []string{
+			"GET http://example.com/items",
+			"HEAD http://example.com/items",
+			"GET http://example.com/items/1",
+			"HEAD http://example.com/items/1",
+		} {
+			cacheEngineMock.EXPECT().Delete(gomock.Any(), key).Return(nil).Times(1)
+		}
+
+		transport := assertTransport(t, NewTransport(cacheEngineMock))
+		req, _ := http.NewRequest(http.MethodPost, "http://example.com/items", nil)
+		res := &http.Response{
+			StatusCode: http.StatusCreated,
+			Header: http.Header{
+				"Location":         {"/items/1"},
+				"Content-Location": {"http://example.org/items/1"},
+			},
+		}
+		transport.invalidate(context.Background(), req, res)
+	})
+
+	t.Run("Safe method does not invalidate", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(ctrl)
+		cacheEngineMock.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(0)
+
+		transport := assertTransport(t, NewTransport(cacheEngineMock))
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/items", nil)
+		transport.invalidate(context.Background(), req, &http.Response{StatusCode: http.StatusOK})
+	})
+
+	t.Run("Error status code does not invalidate", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(ctrl)
+		cacheEngineMock.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(0)
+
+		transport := assertTransport(t, NewTransport(cacheEngineMock))
+		req, _ := http.NewRequest(http.MethodDelete, "http://example.com/items/1", nil)
+		transport.invalidate(context.Background(), req, &http.Response{StatusCode: http.StatusNotFound})
+	})
+}
+
+func TestTransportInvalidateVariants(t *testing.T) {
+	t.Parallel()
+	var counter int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&counter, 1)
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s-%d", r.Header.Get("Accept-Language"), n)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Timeout: 3 * time.Second, Transport: NewTransport(memorycache.New())}
+	do := func(method, acceptLanguage string) string {
+		req, _ := http.NewRequest(method, ts.URL, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		res, err := client.Do(req)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer res.Body.Close()
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return string(resb)
+	}
+
+	assert.Equal(t, "en-1", do(http.MethodGet, "en"))
+	assert.Equal(t, "ja-2", do(http.MethodGet, "ja"))
+	assert.Equal(t, "en-1", do(http.MethodGet, "en"))
+	do(http.MethodPost, "ja")
+
+	// the primary entry is filled by another variant again
+	assert.Equal(t, "ja-4", do(http.MethodGet, "ja"))
+	// the variant stored before the invalidation is not served
+	assert.Equal(t, "en-5", do(http.MethodGet, "en"))
+	assert.Equal(t, "en-5", do(http.MethodGet, "en"))
+	assert.Equal(t, "ja-4", do(http.MethodGet, "ja"))
+	assert.Equal(t, int64(5), atomic.LoadInt64(&counter))
+}
@@ -0,0 +1,83 @@
+package httpclientcache
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// maxLockPollInterval is the longest interval of checking whether the response has been stored by the process holding the lock.
+const maxLockPollInterval = 100 * time.Millisecond
+
+// fetchLocked calls do while holding the lock on the key, if WithLocker is given.
+// If another process holds the lock, it waits for the response to be stored by the process,
+// and calls do if the lock is released without storing a usable response or the wait times out.
+// The cache is checked again once the lock is acquired, in case the response was stored by the previous holder.
+// A failure of the lock is regarded as the lock being acquired.
+// Requests not reusing stored responses, such as those with no-cache, do not use the lock.
+func (t *Transport) fetchLocked(ctx context.Context, key string, req *http.Request, reqCC cacheControl, do func() (*fetched, error)) (*fetched, error) {
+	if policy, _ := PolicyFromContext(ctx); t.locker == nil || reqCC.has("no-cache") || policy.Refresh {
+		return do()
+	}
+	deadline := t.now().Add(t.lockWait)
+	interval := min(maxLockPollInterval, max(t.lockWait/10, time.Millisecond))
+	for {
+		unlock, ok, err := t.locker.TryLock(ctx, key, t.lockTTL)
+		if err != nil {
+			t.logger.ErrorContext(ctx, "fetch without lock because failed to acquire lock", slog.Any("error", err))
+			return do()
+		}
+		if ok {
+			release := func() {
+				if err := unlock(context.WithoutCancel(ctx)); err != nil {
+					t.logger.ErrorContext(ctx, "failed to release lock", slog.Any("error", err))
+				}
+			}
+			// the previous holder may have stored the response just before releasing the lock
+			if f, ok := t.storedByOther(ctx, key, req, reqCC); ok {
+				release()
+				return f, nil
+			}
+			f, err := do()
+			if err == nil && f.stream != nil && f.stream.copying() {
+				// hold the lock until the response streamed with WithStreaming is stored
+				f.stream.onFinish(release)
+			} else {
+				release()
+			}
+			return f, err
+		}
+		if !t.now().Before(deadline) {
+			return do()
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if f, ok := t.storedByOther(ctx, key, req, reqCC); ok {
+			return f, nil
+		}
+	}
+}
+
+// storedByOther returns the usable response stored for req, if any.
+func (t *Transport) storedByOther(ctx context.Context, key string, req *http.Request, reqCC cacheControl) (*fetched, bool) {
+	res, ok, err := t.get(ctx, key, req)
+	if err != nil || !ok {
+		return nil, false
+	}
+	defer res.Body.Close()
+	if !t.usable(res, reqCC) {
+		return nil, false
+	}
+	resb, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return nil, false
+	}
+	return &fetched{resb: resb, status: res.StatusCode, fromCache: true}, true
+}
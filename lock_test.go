@@ -0,0 +1,258 @@
+package httpclientcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/engine/memorycache"
+	mock_engine "github.com/Arthur1/http-client-cache/cache/engine/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestTransportFetchLocked(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	stored := func(t *testing.T, transport *Transport, req *http.Request) {
+		t.Helper()
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("stored")),
+		}
+		_, err := transport.store(ctx, "key", req, res, transport.now(), transport.now())
+		assert.NoError(t, err)
+	}
+	fetchedByDo := &fetched{resb: []byte("fetched"), status: http.StatusOK}
+
+	t.Run("Without locker", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(memorycache.New()))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+	})
+
+	t.Run("If the lock is acquired, fetch and release the lock", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		var unlocked bool
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", time.Minute).Return(func(context.Context) error {
+			unlocked = true
+			return nil
+		}, true, nil)
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker), WithLockTTL(time.Minute)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) {
+			assert.False(t, unlocked)
+			return fetchedByDo, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+		assert.True(t, unlocked)
+	})
+
+	t.Run("If the body is passed through without being stored, release the lock without waiting for it to be read", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		var unlocked bool
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).Return(func(context.Context) error {
+			unlocked = true
+			return nil
+		}, true, nil)
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("Hello, World!"))}
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) {
+			return &fetched{status: http.StatusOK, stream: passThrough(res)}, nil
+		})
+		assert.NoError(t, err)
+		defer f.stream.Close()
+		assert.True(t, unlocked)
+	})
+
+	t.Run("If another holds the lock, wait for the response stored by it", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		transport := assertTransport(t, NewTransport(memorycache.New()))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).DoAndReturn(func(context.Context, string, time.Duration) (func(context.Context) error, bool, error) {
+			stored(t, transport, req)
+			return nil, false, nil
+		})
+		transport.locker = locker
+
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) {
+			t.Error("unexpected fetch")
+			return fetchedByDo, nil
+		})
+		assert.NoError(t, err)
+		assert.True(t, f.fromCache)
+		assert.Contains(t, string(f.resb), "stored")
+	})
+
+	t.Run("If the response is stored by the previous holder, release the lock without fetching", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		transport := assertTransport(t, NewTransport(memorycache.New()))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		var unlocked bool
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).DoAndReturn(func(context.Context, string, time.Duration) (func(context.Context) error, bool, error) {
+			// stored after the cache miss, and the lock released just before acquired
+			stored(t, transport, req)
+			return func(context.Context) error {
+				unlocked = true
+				return nil
+			}, true, nil
+		})
+		transport.locker = locker
+
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) {
+			t.Error("unexpected fetch")
+			return fetchedByDo, nil
+		})
+		assert.NoError(t, err)
+		assert.True(t, f.fromCache)
+		assert.Contains(t, string(f.resb), "stored")
+		assert.True(t, unlocked)
+	})
+
+	t.Run("If the lock is released without storing, acquire the lock and fetch", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		locker := mock_engine.NewMockLocker(ctrl)
+		gomock.InOrder(
+			locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).Times(2),
+			locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).Return(func(context.Context) error { return nil }, true, nil),
+		)
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+	})
+
+	t.Run("If the wait times out, fetch without the lock", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).MinTimes(2)
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker), WithLockWait(50*time.Millisecond)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+	})
+
+	t.Run("If the request is canceled while waiting, return the error", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		ctx, cancel := context.WithCancel(ctx)
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).DoAndReturn(func(context.Context, string, time.Duration) (func(context.Context) error, bool, error) {
+			cancel()
+			return nil, false, nil
+		})
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker)))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+		_, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("If the lock fails, fetch without the lock", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), "key", gomock.Any()).Return(nil, false, errors.New("error"))
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+	})
+
+	t.Run("Requests with no-cache do not use the lock", func(t *testing.T) {
+		t.Parallel()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		locker := mock_engine.NewMockLocker(ctrl)
+		locker.EXPECT().TryLock(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		transport := assertTransport(t, NewTransport(memorycache.New(), WithLocker(locker)))
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		f, err := transport.fetchLocked(ctx, "key", req, cacheControl{"no-cache": ""}, func() (*fetched, error) { return fetchedByDo, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, fetchedByDo, f)
+	})
+}
+
+func TestTransportWithLocker(t *testing.T) {
+	t.Parallel()
+	var counter int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&counter, 1)
+		fmt.Fprintln(w, "origin")
+	}))
+	defer ts.Close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cacheEngine := memorycache.New()
+	// another process sharing the cache engine holds the lock and stores the response
+	other := assertTransport(t, NewTransport(cacheEngine))
+	locker := mock_engine.NewMockLocker(ctrl)
+	locker.EXPECT().TryLock(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, key string, _ time.Duration) (func(context.Context) error, bool, error) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/locker", nil)
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("other\n")),
+		}
+		_, err := other.store(ctx, key, req, res, other.now(), other.now())
+		return nil, false, err
+	})
+
+	transport := NewTransport(cacheEngine, WithLocker(locker), WithCacheStatus(true))
+	client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/locker", nil)
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	resb, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "other\n", string(resb))
+	assert.True(t, strings.HasPrefix(res.Header.Get("Cache-Status"), "http-client-cache; hit"))
+	assert.Equal(t, int64(0), counter)
+}
@@ -0,0 +1,60 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Arthur1/http-client-cache/cache/entry"
+)
+
+// Header fields which Transport adds to stored responses to carry cache metadata through a CacheEngine.
+// They are removed before responses are returned to callers.
+const (
+	metadataHeaderPrefix = entry.MetadataHeaderPrefix
+	headerRequestTime    = entry.HeaderRequestTime
+	headerResponseTime   = entry.HeaderResponseTime
+	headerLifetime       = entry.HeaderLifetime
+	headerVariedPrefix   = entry.HeaderVariedPrefix
+	// time since which the secondary entries of the variants stored along with the response are valid
+	headerVariantsSince = metadataHeaderPrefix + "Variants-Since"
+)
+
+func setResponseTimes(h http.Header, requestTime, responseTime time.Time) {
+	h.Set(headerRequestTime, requestTime.UTC().Format(time.RFC3339Nano))
+	h.Set(headerResponseTime, responseTime.UTC().Format(time.RFC3339Nano))
+}
+
+// responseTimes returns the times at which the stored response was requested and received.
+func responseTimes(h http.Header) (requestTime, responseTime time.Time, ok bool) {
+	requestTime, err := time.Parse(time.RFC3339Nano, h.Get(headerRequestTime))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	responseTime, err = time.Parse(time.RFC3339Nano, h.Get(headerResponseTime))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return requestTime, responseTime, true
+}
+
+// setOverriddenLifetime records the freshness lifetime which overrides the one computed from the response.
+func setOverriddenLifetime(h http.Header, lifetime time.Duration) {
+	h.Set(headerLifetime, lifetime.String())
+}
+
+func overriddenLifetime(h http.Header) (time.Duration, bool) {
+	lifetime, err := time.ParseDuration(h.Get(headerLifetime))
+	if err != nil {
+		return 0, false
+	}
+	return lifetime, true
+}
+
+func stripMetadata(h http.Header) {
+	for name := range h {
+		if strings.HasPrefix(name, metadataHeaderPrefix) {
+			delete(h, name)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseTimes(t *testing.T) {
+	t.Parallel()
+	t.Run("Set and get", func(t *testing.T) {
+		t.Parallel()
+		requestTime := time.Date(2024, 7, 1, 0, 0, 0, 100, time.UTC)
+		responseTime := requestTime.Add(time.Second)
+		h := http.Header{}
+		setResponseTimes(h, requestTime, responseTime)
+
+		gotRequestTime, gotResponseTime, ok := responseTimes(h)
+		assert.True(t, ok)
+		assert.True(t, requestTime.Equal(gotRequestTime))
+		assert.True(t, responseTime.Equal(gotResponseTime))
+	})
+
+	t.Run("No metadata", func(t *testing.T) {
+		t.Parallel()
+		_, _, ok := responseTimes(http.Header{})
+		assert.False(t, ok)
+	})
+}
+
+func TestStripMetadata(t *testing.T) {
+	t.Parallel()
+	h := http.Header{"Content-Type": {"text/plain"}}
+	setResponseTimes(h, time.Now(), time.Now())
+	stripMetadata(h)
+	assert.Equal(t, http.Header{"Content-Type": {"text/plain"}}, h)
+}
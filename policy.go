@@ -0,0 +1,38 @@
+package httpclientcache
+
+import "net/http"
+
+// storable reports whether res for req may be stored in a cache (RFC 9111 Section 3).
+func storable(req *http.Request, res *http.Response, shared bool) bool {
+	cc := parseCacheControl(res.Header)
+	if cc.has("no-store") {
+		return false
+	}
+	if shared {
+		if cc.has("private") {
+			return false
+		}
+		// RFC 9111 Section 3.5
+		if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+			return false
+		}
+	}
+	return true
+}
+
+// requiresValidation reports whether a stored response must not be reused without successful validation on the origin.
+func requiresValidation(h http.Header) bool {
+	return parseCacheControl(h).has("no-cache")
+}
+
+// staleServable reports whether a stored response may be served stale (RFC 9111 Section 4.2.4).
+func staleServable(h http.Header, shared bool) bool {
+	cc := parseCacheControl(h)
+	if cc.has("no-cache") || cc.has("must-revalidate") {
+		return false
+	}
+	if shared && (cc.has("proxy-revalidate") || cc.has("s-maxage")) {
+		return false
+	}
+	return true
+}
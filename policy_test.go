@@ -0,0 +1,88 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		reqHeader     http.Header
+		resHeader     http.Header
+		wantShared    bool
+		wantNotShared bool
+	}{
+		{
+			name:          "no directives",
+			resHeader:     http.Header{},
+			wantShared:    true,
+			wantNotShared: true,
+		},
+		{
+			name:          "no-store",
+			resHeader:     http.Header{"Cache-Control": {"max-age=60, no-store"}},
+			wantShared:    false,
+			wantNotShared: false,
+		},
+		{
+			name:          "private",
+			resHeader:     http.Header{"Cache-Control": {"private, max-age=60"}},
+			wantShared:    false,
+			wantNotShared: true,
+		},
+		{
+			name:          "Authorization without public",
+			reqHeader:     http.Header{"Authorization": {"Bearer token"}},
+			resHeader:     http.Header{"Cache-Control": {"max-age=60"}},
+			wantShared:    false,
+			wantNotShared: true,
+		},
+		{
+			name:          "Authorization with public",
+			reqHeader:     http.Header{"Authorization": {"Bearer token"}},
+			resHeader:     http.Header{"Cache-Control": {"public, max-age=60"}},
+			wantShared:    true,
+			wantNotShared: true,
+		},
+		{
+			name:          "no-cache",
+			resHeader:     http.Header{"Cache-Control": {"no-cache"}},
+			wantShared:    true,
+			wantNotShared: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			if tt.reqHeader != nil {
+				req.Header = tt.reqHeader
+			}
+			res := &http.Response{StatusCode: http.StatusOK, Header: tt.resHeader}
+			assert.Equal(t, tt.wantShared, storable(req, res, true))
+			assert.Equal(t, tt.wantNotShared, storable(req, res, false))
+		})
+	}
+}
+
+func TestRequiresValidation(t *testing.T) {
+	t.Parallel()
+	assert.True(t, requiresValidation(http.Header{"Cache-Control": {"no-cache"}}))
+	assert.True(t, requiresValidation(http.Header{"Cache-Control": {`no-cache="Set-Cookie"`}}))
+	assert.False(t, requiresValidation(http.Header{"Cache-Control": {"max-age=60"}}))
+}
+
+func TestStaleServable(t *testing.T) {
+	t.Parallel()
+	assert.True(t, staleServable(http.Header{"Cache-Control": {"max-age=60"}}, true))
+	assert.False(t, staleServable(http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}, true))
+	assert.False(t, staleServable(http.Header{"Cache-Control": {"no-cache"}}, false))
+	assert.False(t, staleServable(http.Header{"Cache-Control": {"max-age=60, proxy-revalidate"}}, true))
+	assert.True(t, staleServable(http.Header{"Cache-Control": {"max-age=60, proxy-revalidate"}}, false))
+	assert.False(t, staleServable(http.Header{"Cache-Control": {"s-maxage=60"}}, true))
+}
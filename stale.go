@@ -0,0 +1,187 @@
+package httpclientcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// fixedLifetime returns the freshness lifetime counted from the time a response was stored,
+// which is given by Policy.TTL or WithExpiration, and false if it is computed from the response.
+func (t *Transport) fixedLifetime(h http.Header) (time.Duration, bool) {
+	if lifetime, ok := overriddenLifetime(h); ok {
+		return lifetime, true
+	}
+	if !t.respectCacheControl {
+		return t.expiration, true
+	}
+	return 0, false
+}
+
+// lifetime returns the freshness lifetime of a response.
+func (t *Transport) lifetime(h http.Header, responseTime time.Time) time.Duration {
+	if lifetime, ok := t.fixedLifetime(h); ok {
+		return lifetime
+	}
+	return freshnessLifetime(h, responseTime, t.expiration, t.sharedCache)
+}
+
+// age returns the current age of a stored response.
+// For a fixed lifetime, it is the time since the response was stored, since the lifetime is also counted from then.
+// Responses without metadata are regarded as new, since they are expired by the CacheEngine.
+func (t *Transport) age(res *http.Response) time.Duration {
+	_, responseTime, ok := responseTimes(res.Header)
+	if !ok {
+		return 0
+	}
+	if _, ok := t.fixedLifetime(res.Header); ok {
+		return t.now().Sub(responseTime)
+	}
+	age, _ := currentAge(res.Header, t.now())
+	return age
+}
+
+// staleness returns how long the stored response has been stale, which is negative while it is fresh.
+func (t *Transport) staleness(res *http.Response) time.Duration {
+	_, responseTime, ok := responseTimes(res.Header)
+	if !ok {
+		return -1
+	}
+	return t.age(res) - t.lifetime(res.Header, responseTime)
+}
+
+// usable reports whether a stored response can be reused without validation
+// for a request with the Cache-Control directives reqCC (RFC 9111 Section 4.2 and 5.2.1).
+func (t *Transport) usable(res *http.Response, reqCC cacheControl) bool {
+	if t.respectCacheControl && requiresValidation(res.Header) {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && t.age(res) > maxAge {
+		return false
+	}
+	staleness := t.staleness(res)
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		staleness += minFresh
+	}
+	if staleness < 0 {
+		return true
+	}
+	if !reqCC.has("max-stale") || (t.respectCacheControl && !staleServable(res.Header, t.sharedCache)) {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	// max-stale without a value accepts a stale response of any staleness
+	return !ok || staleness <= maxStale
+}
+
+// staleWhileRevalidateWindow returns how long a response can be served stale while it is refreshed in the background.
+func (t *Transport) staleWhileRevalidateWindow(h http.Header) time.Duration {
+	if !t.respectCacheControl {
+		return t.staleWhileRevalidate
+	}
+	if !staleServable(h, t.sharedCache) {
+		return 0
+	}
+	if window, ok := parseCacheControl(h).seconds("stale-while-revalidate"); ok {
+		return window
+	}
+	return t.staleWhileRevalidate
+}
+
+// servableWhileRevalidate reports whether a stale response can be served while it is refreshed in the background.
+// Requests which limit the age or freshness of responses are not served stale.
+func (t *Transport) servableWhileRevalidate(res *http.Response, reqCC cacheControl) bool {
+	if reqCC.has("max-age") || reqCC.has("min-fresh") {
+		return false
+	}
+	staleness := t.staleness(res)
+	return staleness >= 0 && staleness < t.staleWhileRevalidateWindow(res.Header)
+}
+
+// staleIfErrorWindow returns how long a response can be served stale when the origin fails.
+func (t *Transport) staleIfErrorWindow(h http.Header) time.Duration {
+	if !t.respectCacheControl {
+		return t.staleIfError
+	}
+	if !staleServable(h, t.sharedCache) {
+		return 0
+	}
+	if window, ok := parseCacheControl(h).seconds("stale-if-error"); ok {
+		return window
+	}
+	return t.staleIfError
+}
+
+// servableOnError reports whether the stored response should be served instead of the result of retrieving from the origin,
+// which is either res or err (RFC 5861 Section 4).
+func (t *Transport) servableOnError(cachedRes, res *http.Response, err error) bool {
+	if err == nil {
+		if _, ok := t.staleIfErrorStatuses[res.StatusCode]; !ok {
+			return false
+		}
+	}
+	window := t.staleIfErrorWindow(cachedRes.Header)
+	return window > 0 && t.staleness(cachedRes) < window
+}
+
+// ttl returns the duration for which res should be stored, and false if res should not be stored.
+func (t *Transport) ttl(res *http.Response, requestTime, responseTime time.Time) (time.Duration, bool) {
+	if lifetime, ok := t.fixedLifetime(res.Header); ok {
+		return lifetime + max(t.staleWhileRevalidate, t.staleIfError), true
+	}
+	ttl := t.lifetime(res.Header, responseTime) - initialAge(res.Header, requestTime, responseTime)
+	var retention time.Duration
+	if hasValidators(res.Header) {
+		retention = t.staleRetention
+	}
+	retention = max(retention, t.staleWhileRevalidateWindow(res.Header), t.staleIfErrorWindow(res.Header))
+	if retention > 0 {
+		ttl = max(0, ttl) + retention
+	}
+	return ttl, ttl > 0
+}
+
+// refreshInBackground starts refreshing a stale response and returns a copy of it to be served meanwhile.
+// Refreshes of the same key are deduplicated.
+func (t *Transport) refreshInBackground(ctx context.Context, key string, req *http.Request, cachedRes *http.Response) (*http.Response, error) {
+	resb, err := httputil.DumpResponse(cachedRes, true)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resb)), req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := t.detach(ctx)
+	req = detachRequest(ctx, req)
+	go func() {
+		defer cancel()
+		defer cachedRes.Body.Close()
+		_, err, _ := t.group.Do(key, func() (any, error) {
+			var (
+				f   *fetched
+				err error
+			)
+			if t.respectCacheControl && hasValidators(cachedRes.Header) {
+				f, err = t.revalidate(ctx, key, req, cachedRes)
+			} else {
+				f, err = t.fetch(ctx, key, req)
+			}
+			if err == nil && f.stream != nil {
+				// nobody reads the response whose body is passed through
+				return f.stream.drain()
+			}
+			return f, err
+		})
+		if err != nil && !errors.Is(err, errNotShared) {
+			t.logger.ErrorContext(ctx, "failed to refresh stale response in the background", slog.Any("error", err))
+		}
+	}()
+	return res, nil
+}
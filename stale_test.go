@@ -0,0 +1,175 @@
+package httpclientcache
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportStaleness(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	storedAt := now.Add(-90 * time.Second)
+	newResponse := func(cacheControl string) *http.Response {
+		h := http.Header{
+			"Cache-Control": {cacheControl},
+			"Date":          {storedAt.Format(http.TimeFormat)},
+			"Age":           {"20"},
+		}
+		setResponseTimes(h, storedAt, storedAt)
+		return &http.Response{Header: h}
+	}
+
+	t.Run("Expiration since stored is used by default", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithExpiration(time.Minute)))
+		transport.now = func() time.Time { return now }
+		assert.Equal(t, 30*time.Second, transport.staleness(newResponse("max-age=3600")))
+	})
+
+	t.Run("Freshness lifetime and age are used if respecting Cache-Control", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true)))
+		transport.now = func() time.Time { return now }
+		assert.Equal(t, -(3600-110)*time.Second, transport.staleness(newResponse("max-age=3600")))
+		assert.Equal(t, 50*time.Second, transport.staleness(newResponse("max-age=60")))
+	})
+
+	t.Run("Overridden lifetime is used", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true)))
+		transport.now = func() time.Time { return now }
+		res := newResponse("max-age=60")
+		setOverriddenLifetime(res.Header, time.Hour)
+		assert.Equal(t, -(3600-90)*time.Second, transport.staleness(res))
+	})
+
+	t.Run("Responses without metadata are regarded as fresh", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil))
+		assert.True(t, transport.usable(&http.Response{Header: http.Header{}}, cacheControl{}))
+	})
+}
+
+func TestTransportStaleWhileRevalidateWindow(t *testing.T) {
+	t.Parallel()
+	t.Run("Configured window is used by default", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStaleWhileRevalidate(time.Minute)))
+		h := http.Header{"Cache-Control": {"stale-while-revalidate=10, must-revalidate"}}
+		assert.Equal(t, time.Minute, transport.staleWhileRevalidateWindow(h))
+	})
+
+	t.Run("Directive of the response takes precedence if respecting Cache-Control", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true), WithStaleWhileRevalidate(time.Minute)))
+		assert.Equal(t, 10*time.Second, transport.staleWhileRevalidateWindow(http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=10"}}))
+		assert.Equal(t, time.Minute, transport.staleWhileRevalidateWindow(http.Header{"Cache-Control": {"max-age=60"}}))
+		assert.Equal(t, time.Duration(0), transport.staleWhileRevalidateWindow(http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}))
+	})
+}
+
+func TestTransportTTL(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	t.Run("Stale-while-revalidate window is added to expiration", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithExpiration(time.Minute), WithStaleWhileRevalidate(time.Minute)))
+		got, ok := transport.ttl(&http.Response{Header: http.Header{}}, now, now)
+		assert.True(t, ok)
+		assert.Equal(t, 2*time.Minute, got)
+	})
+
+	t.Run("Stale response is kept for the longest window", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true), WithStaleRetention(time.Hour), WithStaleWhileRevalidate(time.Minute)))
+		h := http.Header{"Cache-Control": {"max-age=0"}, "Etag": {`"v1"`}}
+		got, ok := transport.ttl(&http.Response{Header: h}, now, now)
+		assert.True(t, ok)
+		assert.Equal(t, time.Hour, got)
+
+		h = http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30"}}
+		got, ok = transport.ttl(&http.Response{Header: h}, now, now)
+		assert.True(t, ok)
+		assert.Equal(t, 90*time.Second, got)
+	})
+}
+
+func TestTransportServableOnError(t *testing.T) {
+	t.Parallel()
+	newCachedResponse := func(cacheControl string, storedAt time.Time) *http.Response {
+		h := http.Header{"Cache-Control": {cacheControl}}
+		setResponseTimes(h, storedAt, storedAt)
+		return &http.Response{Header: h}
+	}
+	errorRes := &http.Response{StatusCode: http.StatusBadGateway}
+	okRes := &http.Response{StatusCode: http.StatusOK}
+
+	t.Run("Within the configured window", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithExpiration(time.Minute), WithStaleIfError(time.Minute)))
+		cachedRes := newCachedResponse("", time.Now().Add(-90*time.Second))
+		assert.True(t, transport.servableOnError(cachedRes, errorRes, nil))
+		assert.True(t, transport.servableOnError(cachedRes, nil, errors.New("error")))
+		assert.False(t, transport.servableOnError(cachedRes, okRes, nil))
+	})
+
+	t.Run("Outside the configured window", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithExpiration(time.Minute), WithStaleIfError(time.Minute)))
+		cachedRes := newCachedResponse("", time.Now().Add(-3*time.Minute))
+		assert.False(t, transport.servableOnError(cachedRes, errorRes, nil))
+	})
+
+	t.Run("Directive of the response takes precedence if respecting Cache-Control", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true), WithStaleIfError(time.Minute)))
+		cachedRes := newCachedResponse("max-age=60, stale-if-error=600", time.Now().Add(-3*time.Minute))
+		assert.True(t, transport.servableOnError(cachedRes, errorRes, nil))
+		cachedRes = newCachedResponse("max-age=60, must-revalidate", time.Now().Add(-90*time.Second))
+		assert.False(t, transport.servableOnError(cachedRes, errorRes, nil))
+	})
+}
+
+func TestTransportUsable(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	newCachedResponse := func(cacheControl string, age time.Duration) *http.Response {
+		storedAt := now.Add(-age)
+		h := http.Header{"Cache-Control": {cacheControl}, "Date": {storedAt.Format(http.TimeFormat)}}
+		setResponseTimes(h, storedAt, storedAt)
+		return &http.Response{Header: h}
+	}
+	tests := []struct {
+		name         string
+		cacheControl string
+		age          time.Duration
+		request      string
+		want         bool
+	}{
+		{name: "fresh", cacheControl: "max-age=60", age: 30 * time.Second, request: "", want: true},
+		{name: "stale", cacheControl: "max-age=60", age: 90 * time.Second, request: "", want: false},
+		{name: "no-cache", cacheControl: "max-age=60, no-cache", age: 0, request: "", want: false},
+		{name: "within max-age", cacheControl: "max-age=60", age: 30 * time.Second, request: "max-age=40", want: true},
+		{name: "exceeding max-age", cacheControl: "max-age=60", age: 30 * time.Second, request: "max-age=20", want: false},
+		{name: "within min-fresh", cacheControl: "max-age=60", age: 30 * time.Second, request: "min-fresh=20", want: true},
+		{name: "exceeding min-fresh", cacheControl: "max-age=60", age: 30 * time.Second, request: "min-fresh=40", want: false},
+		{name: "within max-stale", cacheControl: "max-age=60", age: 90 * time.Second, request: "max-stale=40", want: true},
+		{name: "exceeding max-stale", cacheControl: "max-age=60", age: 90 * time.Second, request: "max-stale=20", want: false},
+		{name: "max-stale without value", cacheControl: "max-age=60", age: time.Hour, request: "max-stale", want: true},
+		{name: "max-stale with must-revalidate", cacheControl: "max-age=60, must-revalidate", age: 90 * time.Second, request: "max-stale", want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true)))
+			transport.now = func() time.Time { return now }
+			reqCC := parseCacheControl(http.Header{"Cache-Control": {tt.request}})
+			assert.Equal(t, tt.want, transport.usable(newCachedResponse(tt.cacheControl, tt.age), reqCC))
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package httpclientcache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// errNotShared is returned to coalesced requests when the body of the response is passed through to the request which fetched it,
+// such as one streamed with WithStreaming or over WithMaxBodySize, so that they fetch the response by themselves.
+var errNotShared = errors.New("http-client-cache: response was not shared by the request which fetched it")
+
+// stream is the body of a response fetched from the origin, which is passed to the caller without being read entirely in advance.
+// With WithStreaming, the body is copied as the caller reads it, and the response is stored when it is read to the end without an error.
+// The copy is discarded once it exceeds WithMaxBodySize.
+type stream struct {
+	res  *http.Response
+	body io.ReadCloser
+	buf  bytes.Buffer
+	// maximum size of the copy, or zero if unlimited
+	limit int64
+	// commit stores the response with the complete body, and returns the result.
+	// It is nil if the body is passed through without being copied.
+	commit func(body []byte) (*fetched, error)
+	// the body is over limit, and the copy is discarded
+	exceeded bool
+	// called once the body is read to the end or abandoned
+	afterFinish []func()
+
+	once sync.Once
+	done chan struct{}
+	// result and err are set before done is closed
+	result *fetched
+	err    error
+}
+
+var _ io.ReadCloser = (*stream)(nil)
+
+// newStream replaces the body of res with a stream copying it up to limit.
+func newStream(res *http.Response, limit int64, commit func(body []byte) (*fetched, error)) *stream {
+	s := &stream{res: res, body: res.Body, limit: limit, commit: commit, done: make(chan struct{})}
+	s.exceeded = limit > 0 && res.ContentLength > limit
+	res.Body = s
+	return s
+}
+
+// passThrough replaces the body of res with a stream which is not copied.
+func passThrough(res *http.Response) *stream {
+	return newStream(res, 0, nil)
+}
+
+// copying reports whether the body is being copied to be committed, which is false once it is known to be over the limit.
+// It must be called before the body is passed to the caller.
+func (s *stream) copying() bool {
+	return s.commit != nil && !s.exceeded
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if s.commit != nil && !s.exceeded {
+		s.buf.Write(p[:n])
+		if s.limit > 0 && int64(s.buf.Len()) > s.limit {
+			s.exceeded = true
+			s.buf = bytes.Buffer{}
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		s.finish(true)
+	} else if err != nil {
+		s.finish(false)
+	}
+	return n, err
+}
+
+func (s *stream) Close() error {
+	err := s.body.Close()
+	s.finish(false)
+	return err
+}
+
+// finish commits the response if the body is complete and copied. Only the first call takes effect.
+func (s *stream) finish(complete bool) {
+	s.once.Do(func() {
+		if complete && s.commit != nil && !s.exceeded {
+			s.result, s.err = s.commit(s.buf.Bytes())
+		} else {
+			s.err = errNotShared
+		}
+		for _, f := range s.afterFinish {
+			f()
+		}
+		close(s.done)
+	})
+}
+
+// onFinish registers f to be called once the body is read to the end or abandoned.
+// It must be called before the body is passed to the caller.
+func (s *stream) onFinish(f func()) {
+	s.afterFinish = append(s.afterFinish, f)
+}
+
+// drain reads the body to the end on behalf of the caller, and returns the result of committing it.
+func (s *stream) drain() (*fetched, error) {
+	_, err := io.Copy(io.Discard, s)
+	s.Close()
+	if err != nil {
+		return nil, err
+	}
+	return s.wait()
+}
+
+// wait waits until the body is read to the end or abandoned, and returns the result of committing it.
+func (s *stream) wait() (*fetched, error) {
+	<-s.done
+	return s.result, s.err
+}
@@ -0,0 +1,304 @@
+package httpclientcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	mock_engine "github.com/Arthur1/http-client-cache/cache/engine/mock"
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	newResponse := func(body string) *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	t.Run("The body read to the end is committed", func(t *testing.T) {
+		t.Parallel()
+		var committed []string
+		finished := 0
+		s := newStream(newResponse("Hello, World!"), 0, func(body []byte) (*fetched, error) {
+			committed = append(committed, string(body))
+			return &fetched{resb: body, stored: true}, nil
+		})
+		s.onFinish(func() { finished++ })
+		assert.True(t, s.copying())
+
+		got, err := io.ReadAll(s.res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello, World!", string(got))
+		assert.NoError(t, s.res.Body.Close())
+
+		f, err := s.wait()
+		assert.NoError(t, err)
+		assert.True(t, f.stored)
+		assert.Equal(t, []string{"Hello, World!"}, committed)
+		assert.Equal(t, 1, finished)
+	})
+
+	t.Run("The body closed before the end is not committed", func(t *testing.T) {
+		t.Parallel()
+		finished := 0
+		s := newStream(newResponse("Hello, World!"), 0, func(body []byte) (*fetched, error) {
+			t.Error("commit must not be called")
+			return nil, nil
+		})
+		s.onFinish(func() { finished++ })
+
+		_, err := io.ReadFull(s.res.Body, make([]byte, 5))
+		assert.NoError(t, err)
+		assert.NoError(t, s.res.Body.Close())
+
+		_, err = s.wait()
+		assert.ErrorIs(t, err, errNotShared)
+		assert.Equal(t, 1, finished)
+	})
+
+	t.Run("The body failing to be read is not committed", func(t *testing.T) {
+		t.Parallel()
+		res := newResponse("")
+		res.Body = io.NopCloser(io.MultiReader(strings.NewReader("Hello"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+		s := newStream(res, 0, func(body []byte) (*fetched, error) {
+			t.Error("commit must not be called")
+			return nil, nil
+		})
+
+		_, err := io.ReadAll(s.res.Body)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		_, err = s.wait()
+		assert.ErrorIs(t, err, errNotShared)
+	})
+
+	t.Run("The body over the limit is not committed", func(t *testing.T) {
+		t.Parallel()
+		s := newStream(newResponse("Hello, World!"), 8, func(body []byte) (*fetched, error) {
+			t.Error("commit must not be called")
+			return nil, nil
+		})
+
+		got, err := io.ReadAll(s.res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello, World!", string(got))
+		assert.Zero(t, s.buf.Cap())
+		_, err = s.wait()
+		assert.ErrorIs(t, err, errNotShared)
+	})
+
+	t.Run("The body with Content-Length over the limit is not copied", func(t *testing.T) {
+		t.Parallel()
+		res := newResponse("Hello, World!")
+		res.ContentLength = 13
+		s := newStream(res, 8, func(body []byte) (*fetched, error) {
+			t.Error("commit must not be called")
+			return nil, nil
+		})
+		assert.False(t, s.copying())
+
+		_, err := io.ReadFull(s.res.Body, make([]byte, 5))
+		assert.NoError(t, err)
+		assert.Zero(t, s.buf.Len())
+		assert.NoError(t, s.res.Body.Close())
+	})
+
+	t.Run("The body passed through is not committed", func(t *testing.T) {
+		t.Parallel()
+		s := passThrough(newResponse("Hello, World!"))
+		assert.False(t, s.copying())
+		f, err := s.drain()
+		assert.Nil(t, f)
+		assert.ErrorIs(t, err, errNotShared)
+	})
+
+	t.Run("drain reads the body on behalf of the caller", func(t *testing.T) {
+		t.Parallel()
+		s := newStream(newResponse("Hello, World!"), 0, func(body []byte) (*fetched, error) {
+			return &fetched{resb: body}, nil
+		})
+		f, err := s.drain()
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello, World!", string(f.resb))
+	})
+}
+
+func TestTransportWithStreaming(t *testing.T) {
+	t.Parallel()
+	// newOrigin returns an origin which responds "Hello, " at once and "World!" after release is closed.
+	newOrigin := func(t *testing.T) (url string, requested *atomic.Int32, release chan struct{}) {
+		t.Helper()
+		requested, release = &atomic.Int32{}, make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested.Add(1)
+			w.Write([]byte("Hello, "))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte("World!"))
+		}))
+		t.Cleanup(ts.Close)
+		return ts.URL, requested, release
+	}
+	newCacheEngineMock := func(t *testing.T, ctrl *gomock.Controller) *mock_engine.MockCacheEngine {
+		t.Helper()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		return cacheEngineMock
+	}
+	setBody := func(body *atomic.Value) func(context.Context, string, *http.Response, time.Duration) error {
+		return func(_ context.Context, _ string, res *http.Response, _ time.Duration) error {
+			b, err := io.ReadAll(res.Body)
+			body.Store(string(b))
+			return err
+		}
+	}
+
+	t.Run("The body is returned before it is read entirely from the origin, and stored after read to the end", func(t *testing.T) {
+		t.Parallel()
+		url, _, release := newOrigin(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(t, ctrl)
+		var stored atomic.Value
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).DoAndReturn(setBody(&stored))
+		transport := NewTransport(cacheEngineMock, WithStreaming(true))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		res, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		got := make([]byte, 7)
+		_, err = io.ReadFull(res.Body, got)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello, ", string(got))
+		assert.Nil(t, stored.Load())
+
+		close(release)
+		rest, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "World!", string(rest))
+		assert.Equal(t, "Hello, World!", stored.Load())
+		assert.Empty(t, res.Header.Get(headerResponseTime))
+	})
+
+	t.Run("The body closed before the end is not stored", func(t *testing.T) {
+		t.Parallel()
+		url, _, release := newOrigin(t)
+		defer close(release)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(t, ctrl)
+		transport := NewTransport(cacheEngineMock, WithStreaming(true))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		res, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+		_, err = io.ReadFull(res.Body, make([]byte, 7))
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+	})
+
+	t.Run("The body broken by the origin is not stored", func(t *testing.T) {
+		t.Parallel()
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "13")
+			w.Write([]byte("Hello, "))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}))
+		defer ts.Close()
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(t, ctrl)
+		transport := NewTransport(cacheEngineMock, WithStreaming(true))
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		res, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		_, err = io.ReadAll(res.Body)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("A request does not wait for the body streamed to another request", func(t *testing.T) {
+		t.Parallel()
+		url, requested, release := newOrigin(t)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(t, ctrl)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).Return(nil).Times(2)
+		transport := NewTransport(cacheEngineMock, WithStreaming(true))
+
+		req1, _ := http.NewRequest(http.MethodGet, url, nil)
+		res1, err := transport.RoundTrip(req1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer res1.Body.Close()
+
+		// requested from the same goroutine while the first body is open
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		req2, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		res2, err := transport.RoundTrip(req2)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer res2.Body.Close()
+
+		close(release)
+		for _, res := range []*http.Response{res1, res2} {
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "Hello, World!", string(b))
+		}
+		assert.Equal(t, int32(2), requested.Load())
+	})
+
+	t.Run("Coalesced requests fetch the response by themselves if the request streaming it abandons the body", func(t *testing.T) {
+		t.Parallel()
+		url, requested, release := newOrigin(t)
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := newCacheEngineMock(t, ctrl)
+		var stored atomic.Value
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).DoAndReturn(setBody(&stored))
+		transport := NewTransport(cacheEngineMock, WithStreaming(true))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		res, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+
+		followerBody := make(chan string)
+		go func() {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			res, err := transport.RoundTrip(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			followerBody <- string(b)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		res.Body.Close()
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+
+		assert.Equal(t, "Hello, World!", <-followerBody)
+		assert.Equal(t, "Hello, World!", stored.Load())
+		assert.Equal(t, int32(2), requested.Load())
+	})
+}
@@ -3,9 +3,13 @@ package httpclientcache
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -19,6 +23,21 @@ type Transport struct {
 	cacheableStatusCodes map[int]struct{}
 	logger               *slog.Logger
 	expiration           time.Duration
+	respectCacheControl  bool
+	sharedCache          bool
+	staleRetention       time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	staleIfErrorStatuses map[int]struct{}
+	emitCacheStatus      bool
+	locker               engine.Locker
+	lockTTL              time.Duration
+	lockWait             time.Duration
+	group                *singleflight.Group
+	fetchTimeout         time.Duration
+	streaming            bool
+	maxBodySize          int64
+	now                  func() time.Time
 }
 
 var (
@@ -26,6 +45,17 @@ var (
 	defaultLogger               = slog.Default()
 	defaultCacheableStatusCodes = map[int]struct{}{http.StatusOK: {}}
 	defaultExpiration           = 1 * time.Minute
+	defaultSharedCache          = true
+	defaultLockTTL              = 10 * time.Second
+	defaultLockWait             = 5 * time.Second
+	// the shortest timeout of the shared fetch derived from the deadline of the request which started it
+	defaultFetchTimeout         = 30 * time.Second
+	defaultStaleIfErrorStatuses = map[int]struct{}{
+		http.StatusInternalServerError: {},
+		http.StatusBadGateway:          {},
+		http.StatusServiceUnavailable:  {},
+		http.StatusGatewayTimeout:      {},
+	}
 )
 
 type options struct {
@@ -33,6 +63,20 @@ type options struct {
 	cacheableStatusCodes map[int]struct{}
 	logger               *slog.Logger
 	expiration           time.Duration
+	respectCacheControl  bool
+	sharedCache          bool
+	staleRetention       time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	staleIfErrorStatuses map[int]struct{}
+	emitCacheStatus      bool
+	locker               engine.Locker
+	lockTTL              time.Duration
+	lockWait             time.Duration
+	group                *singleflight.Group
+	fetchTimeout         time.Duration
+	streaming            bool
+	maxBodySize          int64
 }
 
 type Option interface {
@@ -44,6 +88,20 @@ var (
 	_ Option = cacheableStatusCodesOption{}
 	_ Option = loggerOption{}
 	_ Option = expirationOption(0)
+	_ Option = respectCacheControlOption(false)
+	_ Option = sharedCacheOption(false)
+	_ Option = staleRetentionOption(0)
+	_ Option = staleWhileRevalidateOption(0)
+	_ Option = staleIfErrorOption(0)
+	_ Option = staleIfErrorStatusCodesOption{}
+	_ Option = cacheStatusOption(false)
+	_ Option = lockerOption{}
+	_ Option = lockTTLOption(0)
+	_ Option = lockWaitOption(0)
+	_ Option = singleflightGroupOption{}
+	_ Option = fetchTimeoutOption(0)
+	_ Option = streamingOption(false)
+	_ Option = maxBodySizeOption(0)
 )
 
 type baseOption struct {
@@ -89,6 +147,194 @@ func WithExpiration(expiration time.Duration) expirationOption {
 	return expirationOption(expiration)
 }
 
+type respectCacheControlOption bool
+
+func (o respectCacheControlOption) apply(opts *options) {
+	opts.respectCacheControl = bool(o)
+}
+
+// WithRespectCacheControl enables caching according to RFC 9111.
+// The expiration of a cached response is computed from its Cache-Control max-age / s-maxage, Expires and Date headers,
+// and the value of WithExpiration is used only when the response does not specify its freshness lifetime.
+func WithRespectCacheControl(respect bool) respectCacheControlOption {
+	return respectCacheControlOption(respect)
+}
+
+type sharedCacheOption bool
+
+func (o sharedCacheOption) apply(opts *options) {
+	opts.sharedCache = bool(o)
+}
+
+// WithSharedCache specifies whether the cache is shared by multiple users (default true).
+// It takes effect only with WithRespectCacheControl(true).
+// A shared cache does not store responses marked as private or responses to requests with Authorization header,
+// and honors s-maxage.
+func WithSharedCache(shared bool) sharedCacheOption {
+	return sharedCacheOption(shared)
+}
+
+type staleRetentionOption time.Duration
+
+func (o staleRetentionOption) apply(opts *options) {
+	opts.staleRetention = time.Duration(o)
+}
+
+// WithStaleRetention specifies how long responses having validators (ETag or Last-Modified) are kept after they become stale.
+// It takes effect only with WithRespectCacheControl(true).
+// A stale response kept in the cache is validated with a conditional request, and reused if the origin responds 304 Not Modified.
+func WithStaleRetention(retention time.Duration) staleRetentionOption {
+	return staleRetentionOption(retention)
+}
+
+type staleWhileRevalidateOption time.Duration
+
+func (o staleWhileRevalidateOption) apply(opts *options) {
+	opts.staleWhileRevalidate = time.Duration(o)
+}
+
+// WithStaleWhileRevalidate specifies how long responses are kept after they become stale,
+// during which a stale response is returned immediately and refreshed in the background.
+// With WithRespectCacheControl(true), the stale-while-revalidate directive of the response takes precedence.
+func WithStaleWhileRevalidate(window time.Duration) staleWhileRevalidateOption {
+	return staleWhileRevalidateOption(window)
+}
+
+type staleIfErrorOption time.Duration
+
+func (o staleIfErrorOption) apply(opts *options) {
+	opts.staleIfError = time.Duration(o)
+}
+
+// WithStaleIfError specifies how long responses are kept after they become stale,
+// during which a stale response is returned instead of a network error or an error status code from the origin.
+// With WithRespectCacheControl(true), the stale-if-error directive of the response takes precedence.
+func WithStaleIfError(window time.Duration) staleIfErrorOption {
+	return staleIfErrorOption(window)
+}
+
+type staleIfErrorStatusCodesOption []int
+
+func (o staleIfErrorStatusCodesOption) apply(opts *options) {
+	opts.staleIfErrorStatuses = map[int]struct{}{}
+	for _, statusCode := range o {
+		opts.staleIfErrorStatuses[statusCode] = struct{}{}
+	}
+}
+
+// WithStaleIfErrorStatusCodes specifies the status codes from the origin for which stale responses are returned instead.
+// The default is 500, 502, 503 and 504.
+func WithStaleIfErrorStatusCodes(statusCodes []int) staleIfErrorStatusCodesOption {
+	return staleIfErrorStatusCodesOption(statusCodes)
+}
+
+type cacheStatusOption bool
+
+func (o cacheStatusOption) apply(opts *options) {
+	opts.emitCacheStatus = bool(o)
+}
+
+// WithCacheStatus specifies whether to add the Cache-Status header (RFC 9211) to responses,
+// and the Age header to responses served from the cache.
+func WithCacheStatus(enabled bool) cacheStatusOption {
+	return cacheStatusOption(enabled)
+}
+
+type lockerOption struct {
+	locker engine.Locker
+}
+
+func (o lockerOption) apply(opts *options) {
+	opts.locker = o.locker
+}
+
+// WithLocker coalesces requests for the same key across processes sharing the cache.
+// While a process holds the lock on a key and fetches the response from the origin,
+// the others wait for the response to be stored, up to the duration given by WithLockWait.
+func WithLocker(locker engine.Locker) lockerOption {
+	return lockerOption{locker}
+}
+
+type lockTTLOption time.Duration
+
+func (o lockTTLOption) apply(opts *options) {
+	opts.lockTTL = time.Duration(o)
+}
+
+// WithLockTTL specifies how long the lock is held at most, in case the process holding it dies (default 10 seconds).
+func WithLockTTL(ttl time.Duration) lockTTLOption {
+	return lockTTLOption(ttl)
+}
+
+type lockWaitOption time.Duration
+
+func (o lockWaitOption) apply(opts *options) {
+	opts.lockWait = time.Duration(o)
+}
+
+// WithLockWait specifies how long to wait for another process holding the lock before fetching from the origin anyway (default 5 seconds).
+func WithLockWait(wait time.Duration) lockWaitOption {
+	return lockWaitOption(wait)
+}
+
+type singleflightGroupOption struct {
+	group *singleflight.Group
+}
+
+func (o singleflightGroupOption) apply(opts *options) {
+	opts.group = o.group
+}
+
+// WithSingleflightGroup specifies the group coalescing concurrent requests for the same key.
+// By default, each Transport has its own group. Transports given the same group coalesce requests with each other,
+// so they should share the cache engine and the base transport.
+func WithSingleflightGroup(group *singleflight.Group) singleflightGroupOption {
+	return singleflightGroupOption{group}
+}
+
+type fetchTimeoutOption time.Duration
+
+func (o fetchTimeoutOption) apply(opts *options) {
+	opts.fetchTimeout = time.Duration(o)
+}
+
+// WithFetchTimeout specifies the timeout of retrieving a response from the origin on behalf of coalesced requests.
+// The retrieval is not canceled by the cancellation of the requests, which only stop waiting for it.
+// By default, it times out at the deadline of the request which started it, but not within 30 seconds,
+// and has no timeout if the request has no deadline. A negative value means no timeout.
+func WithFetchTimeout(timeout time.Duration) fetchTimeoutOption {
+	return fetchTimeoutOption(timeout)
+}
+
+type streamingOption bool
+
+func (o streamingOption) apply(opts *options) {
+	opts.streaming = bool(o)
+}
+
+// WithStreaming specifies whether to return responses from the origin to the caller as they arrive,
+// instead of reading their bodies entirely before returning them.
+// The body is copied as the caller reads it, and the response is stored only when the body is read to the end without an error.
+// Coalesced requests wait only for the header, and then fetch the response by themselves instead of waiting for the body.
+// The fetch streamed to the caller is bound by the context of the caller's request instead of WithFetchTimeout.
+func WithStreaming(enabled bool) streamingOption {
+	return streamingOption(enabled)
+}
+
+type maxBodySizeOption int64
+
+func (o maxBodySizeOption) apply(opts *options) {
+	opts.maxBodySize = int64(o)
+}
+
+// WithMaxBodySize specifies the maximum size of response bodies to be stored. Zero or less means no limit, which is the default.
+// A response whose Content-Length is over the limit is passed through to the caller without being buffered,
+// and one of unknown length is passed through as soon as the body read so far exceeds the limit.
+// Such responses are not shared with coalesced requests, which fetch them by themselves.
+func WithMaxBodySize(size int64) maxBodySizeOption {
+	return maxBodySizeOption(size)
+}
+
 func WithCacheableStatusCodes(statusCodes []int) cacheableStatusCodesOption {
 	return cacheableStatusCodesOption(statusCodes)
 }
@@ -99,10 +345,17 @@ func NewTransport(cacheEngine engine.CacheEngine, opts ...Option) http.RoundTrip
 		logger:               defaultLogger,
 		cacheableStatusCodes: defaultCacheableStatusCodes,
 		expiration:           defaultExpiration,
+		sharedCache:          defaultSharedCache,
+		staleIfErrorStatuses: defaultStaleIfErrorStatuses,
+		lockTTL:              defaultLockTTL,
+		lockWait:             defaultLockWait,
 	}
 	for _, o := range opts {
 		o.apply(options)
 	}
+	if options.group == nil {
+		options.group = &singleflight.Group{}
+	}
 
 	return &Transport{
 		cacheEngine:          cacheEngine,
@@ -110,46 +363,460 @@ func NewTransport(cacheEngine engine.CacheEngine, opts ...Option) http.RoundTrip
 		logger:               options.logger,
 		cacheableStatusCodes: options.cacheableStatusCodes,
 		expiration:           options.expiration,
+		respectCacheControl:  options.respectCacheControl,
+		sharedCache:          options.sharedCache,
+		staleRetention:       options.staleRetention,
+		staleWhileRevalidate: options.staleWhileRevalidate,
+		staleIfError:         options.staleIfError,
+		staleIfErrorStatuses: options.staleIfErrorStatuses,
+		emitCacheStatus:      options.emitCacheStatus,
+		locker:               options.locker,
+		lockTTL:              options.lockTTL,
+		lockWait:             options.lockWait,
+		group:                options.group,
+		fetchTimeout:         options.fetchTimeout,
+		streaming:            options.streaming,
+		maxBodySize:          options.maxBodySize,
+		now:                  time.Now,
 	}
 }
 
-var group singleflight.Group
-
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
+	reqCC := parseRequestCacheControl(req.Header)
+	policy, _ := PolicyFromContext(ctx)
+	if policy.Bypass {
+		return t.through(req, reqCC)
+	}
 	key, err := t.cacheEngine.Key(req)
 	if err != nil {
 		t.logger.ErrorContext(ctx, "through http-client-cache because failed to generate cache key", slog.Any("error", err))
-		return t.base.RoundTrip(req)
+		return t.through(req, reqCC)
 	}
 
-	cachedRes, ok, err := t.cacheEngine.Get(ctx, key, req)
-	if err != nil {
-		t.logger.ErrorContext(ctx, "through http-client-cache because failed to get from cache", slog.Any("error", err))
-		return t.base.RoundTrip(req)
+	var (
+		cachedRes *http.Response
+		ok        bool
+	)
+	status := cacheStatus{key: key, fwd: "uri-miss"}
+	if reqCC.has("no-cache") || policy.Refresh {
+		status.fwd = "request"
+	} else {
+		cachedRes, ok, err = t.get(ctx, key, req)
+		if err != nil {
+			t.logger.ErrorContext(ctx, "through http-client-cache because failed to get from cache", slog.Any("error", err))
+			return t.through(req, reqCC)
+		}
 	}
 	if ok {
-		// cache hit
-		return cachedRes, nil
+		if t.usable(cachedRes, reqCC) {
+			// cache hit
+			return t.prepareResponse(cachedRes, cacheStatus{key: key, hit: true}, true), nil
+		}
+		if t.servableWhileRevalidate(cachedRes, reqCC) {
+			res, err := t.refreshInBackground(ctx, key, req, cachedRes)
+			if err == nil {
+				return t.prepareResponse(res, cacheStatus{key: key, hit: true}, true), nil
+			}
+			t.logger.ErrorContext(ctx, "failed to refresh stale response in the background", slog.Any("error", err))
+		}
+		status.fwd = "stale"
+	}
+	if reqCC.has("only-if-cached") {
+		if ok {
+			cachedRes.Body.Close()
+		}
+		return t.prepareResponse(gatewayTimeout(req), cacheStatus{key: key, detail: "only-if-cached"}, false), nil
 	}
+	validate := ok && t.respectCacheControl && hasValidators(cachedRes.Header)
 
-	maybeResb, err, _ := group.Do(key, func() (any, error) {
-		res, err := t.base.RoundTrip(req)
-		if err != nil {
-			return nil, err
+	f, leader, shared, err := t.coalesce(ctx, key, req, func(ctx context.Context, req *http.Request) (*fetched, error) {
+		return t.fetchLocked(ctx, key, req, reqCC, func() (*fetched, error) {
+			if validate {
+				return t.revalidate(ctx, key, req, cachedRes)
+			}
+			return t.fetch(ctx, key, req)
+		})
+	})
+	if err != nil && ctx.Err() != nil {
+		// the caller abandoned the wait, while the shared fetch may still be using cachedRes
+		return nil, err
+	}
+	var res *http.Response
+	if err == nil {
+		res, err = f.response(req)
+		if err == nil && f.fromCache && (!shared || variantMatches(res.Header, req)) {
+			// stored by another process holding the lock
+			if ok {
+				cachedRes.Body.Close()
+			}
+			return t.prepareResponse(res, cacheStatus{key: key, hit: true}, true), nil
+		}
+		status.fwdStatus, status.stored, status.collapsed = f.status, f.stored, !leader
+	}
+	if errors.Is(err, errNotShared) || (err == nil && shared && !variantMatches(res.Header, req)) {
+		// the response shared by another request is a different variant, or was not shared by the request which fetched it
+		if res != nil {
+			res.Body.Close()
+		}
+		var f *fetched
+		f, err = t.fetch(ctx, key, req)
+		if err == nil {
+			status.fwdStatus, status.stored, status.collapsed = f.status, f.stored, false
+			res, err = f.response(req)
+		}
+	}
+	if ok {
+		if t.servableOnError(cachedRes, res, err) {
+			if res != nil {
+				res.Body.Close()
+			}
+			t.logger.WarnContext(ctx, "serve stale response because failed to retrieve from origin", slog.Any("error", err), slog.Int("status", status.fwdStatus))
+			cachedRes.Header.Add("Warning", `111 - "Revalidation Failed"`)
+			status.stored, status.collapsed = false, false
+			return t.prepareResponse(cachedRes, status, true), nil
 		}
-		if _, ok := t.cacheableStatusCodes[res.StatusCode]; ok {
-			if err := t.cacheEngine.Set(ctx, key, res, t.expiration); err != nil {
-				t.logger.ErrorContext(ctx, "through http-client-cache because failed to set to cache", slog.Any("error", err))
+		cachedRes.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t.prepareResponse(res, status, status.fwdStatus == http.StatusNotModified), nil
+}
+
+// through sends req to the origin bypassing the cache.
+func (t *Transport) through(req *http.Request, reqCC cacheControl) (*http.Response, error) {
+	if reqCC.has("only-if-cached") {
+		return t.prepareResponse(gatewayTimeout(req), cacheStatus{detail: "only-if-cached"}, false), nil
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.prepareResponse(res, cacheStatus{fwd: "bypass", fwdStatus: res.StatusCode}, false), nil
+}
+
+// gatewayTimeout returns the response to a request with only-if-cached which cannot be satisfied by the cache (RFC 9111 Section 5.2.1.7).
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// get retrieves the stored response selected for req.
+// If the response varies by request header fields and the primary entry is another variant, the secondary entry is looked up.
+// The secondary entry is ignored if it was stored before the variants were invalidated.
+func (t *Transport) get(ctx context.Context, key string, req *http.Request) (*http.Response, bool, error) {
+	primary, ok, err := t.cacheEngine.Get(ctx, key, req)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	fields := varyFields(primary.Header)
+	if len(fields) == 0 || variantMatches(primary.Header, req) {
+		return primary, true, nil
+	}
+	primary.Body.Close()
+	if varyAll(primary.Header) {
+		return nil, false, nil
+	}
+	res, ok, err := t.cacheEngine.Get(ctx, variantKey(key, fields, req), req)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if !variantMatches(res.Header, req) || !variantCurrent(primary.Header, res.Header) {
+		res.Body.Close()
+		return nil, false, nil
+	}
+	return res, true, nil
+}
+
+// fetched is a response retrieved from the origin, which is shared by coalesced requests.
+type fetched struct {
+	// serialized response to be returned
+	resb []byte
+	// status code responded by the origin
+	status int
+	stored bool
+	// the response was stored by another process instead of being fetched
+	fromCache bool
+	// the response whose body is passed through to the caller, which is not shared and has no resb
+	stream *stream
+}
+
+// response returns the response to be returned to the caller.
+func (f *fetched) response(req *http.Request) (*http.Response, error) {
+	if f.stream != nil {
+		return f.stream.res, nil
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(f.resb)), req)
+}
+
+// coalesce calls fn once for concurrent requests with the same key, and returns its result to all of them.
+// fn is called with a copy of req detached from the cancellation of any single request, limited by its own timeout instead (see detach),
+// so that the result is not lost when the request which started it is canceled.
+// Each request stops waiting when its own context is done.
+// With WithStreaming, fn is called with req itself instead.
+// A response whose body is passed through, such as one being streamed, is handed to req alone, and the others fetch it by themselves.
+// leader reports whether fn was called for req, and shared whether the result was returned to other requests.
+func (t *Transport) coalesce(ctx context.Context, key string, req *http.Request, fn func(ctx context.Context, req *http.Request) (*fetched, error)) (f *fetched, leader, shared bool, err error) {
+	// called is written by the goroutine calling fn, and read only after receiving its result
+	called := false
+	handoff := make(chan *fetched)
+	ch := t.group.DoChan(key, func() (any, error) {
+		called = true
+		if t.streaming {
+			return streamTo(ctx, req, fn, handoff)
+		}
+		dctx, cancel := t.detach(ctx)
+		f, err := fn(dctx, detachRequest(dctx, req))
+		if err != nil || f.stream == nil {
+			cancel()
+			return f, err
+		}
+		// the body is still read with the detached context
+		f.stream.onFinish(cancel)
+		return handOver(ctx, f, handoff)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, false, false, ctx.Err()
+	case f := <-handoff:
+		return f, true, false, nil
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, called, r.Shared, r.Err
+		}
+		return r.Val.(*fetched), called, r.Shared, nil
+	}
+}
+
+// streamTo calls fn for req, and hands the response being streamed over to req.
+// It returns errNotShared if req is canceled before receiving the response.
+func streamTo(ctx context.Context, req *http.Request, fn func(ctx context.Context, req *http.Request) (*fetched, error), handoff chan<- *fetched) (*fetched, error) {
+	f, err := fn(ctx, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errNotShared
+		}
+		return nil, err
+	}
+	if f.stream == nil {
+		return f, nil
+	}
+	return handOver(ctx, f, handoff)
+}
+
+// handOver passes the response whose body is passed through to the request with ctx, which is waiting for handoff.
+// It returns errNotShared as soon as the response is handed over or abandoned, so that the coalesced requests fetch the response
+// by themselves instead of waiting for the body to be read, which may never happen while the caller keeps it open.
+func handOver(ctx context.Context, f *fetched, handoff chan<- *fetched) (*fetched, error) {
+	select {
+	case handoff <- f:
+	case <-ctx.Done():
+		f.stream.Close()
+	}
+	return nil, errNotShared
+}
+
+// detach returns a context which keeps the values of ctx but not its cancellation, and times out after WithFetchTimeout.
+// Without WithFetchTimeout, it times out at the deadline of ctx extended to defaultFetchTimeout from now,
+// so that a short deadline of one request does not fail the others while a hung origin does not hold the fetch forever.
+func (t *Transport) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	ctx = context.WithoutCancel(ctx)
+	switch {
+	case t.fetchTimeout > 0:
+		return context.WithTimeout(ctx, t.fetchTimeout)
+	case t.fetchTimeout < 0 || !ok:
+		return context.WithCancel(ctx)
+	}
+	if earliest := time.Now().Add(defaultFetchTimeout); deadline.Before(earliest) {
+		deadline = earliest
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// detachRequest returns a copy of req with ctx returned by detach.
+// Request.Cancel is cleared as well, since http.Client closes it on Client.Timeout if its Transport is not *http.Transport.
+func detachRequest(ctx context.Context, req *http.Request) *http.Request {
+	req = req.Clone(ctx)
+	req.Cancel = nil
+	return req
+}
+
+// fetch retrieves a response from the origin and stores it.
+func (t *Transport) fetch(ctx context.Context, key string, req *http.Request) (*fetched, error) {
+	requestTime := t.now()
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.invalidate(ctx, req, res)
+	return t.receive(ctx, key, req, res, requestTime, t.now())
+}
+
+// revalidate validates a stale response with a conditional request (RFC 9111 Section 4.3).
+func (t *Transport) revalidate(ctx context.Context, key string, req *http.Request, cachedRes *http.Response) (*fetched, error) {
+	requestTime := t.now()
+	res, err := t.base.RoundTrip(conditionalRequest(ctx, req, cachedRes))
+	if err != nil {
+		return nil, err
+	}
+	responseTime := t.now()
+	if res.StatusCode != http.StatusNotModified {
+		return t.receive(ctx, key, req, res, requestTime, responseTime)
+	}
+	res.Body.Close()
+	if !notModifiedMatches(res, cachedRes) {
+		return t.fetch(ctx, key, req)
+	}
+	updateStoredHeader(cachedRes.Header, res.Header)
+	f, err := t.store(ctx, key, req, cachedRes, requestTime, responseTime)
+	if err != nil {
+		return nil, err
+	}
+	f.status = http.StatusNotModified
+	return f, nil
+}
+
+// receive handles a response retrieved from the origin.
+// With WithStreaming, it returns the response to be streamed to the caller and stored once its body is read to the end.
+// Otherwise it stores the response at once, unless the body is over WithMaxBodySize.
+func (t *Transport) receive(ctx context.Context, key string, req *http.Request, res *http.Response, requestTime, responseTime time.Time) (*fetched, error) {
+	if !t.streaming {
+		if over, err := t.overMaxBodySize(res); err != nil || over {
+			if err != nil {
+				return nil, err
 			}
+			return &fetched{status: res.StatusCode, stream: passThrough(res)}, nil
 		}
-		resb, err := httputil.DumpResponse(res, true)
-		return resb, err
+		return t.store(ctx, key, req, res, requestTime, responseTime)
+	}
+	ttl, storable := t.prepareToStore(req, res, requestTime, responseTime)
+	// the header of the response returned to the caller is stripped of the metadata
+	header := res.Header.Clone()
+	s := newStream(res, t.maxBodySize, func(body []byte) (*fetched, error) {
+		complete := *res
+		complete.Header = header
+		complete.Body = io.NopCloser(bytes.NewReader(body))
+		stored := storable && t.set(ctx, key, req, &complete, ttl)
+		return &fetched{status: res.StatusCode, stored: stored}, nil
 	})
+	return &fetched{status: res.StatusCode, stream: s}, nil
+}
+
+// overMaxBodySize reports whether the body of res is over WithMaxBodySize.
+// A body of unknown length is read up to the limit, and res is left with the whole body to be read again.
+func (t *Transport) overMaxBodySize(res *http.Response) (bool, error) {
+	if t.maxBodySize <= 0 {
+		return false, nil
+	}
+	if res.ContentLength >= 0 {
+		return res.ContentLength > t.maxBodySize, nil
+	}
+	head, err := io.ReadAll(io.LimitReader(res.Body, t.maxBodySize+1))
+	if err != nil {
+		res.Body.Close()
+		return false, err
+	}
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), res.Body), res.Body}
+	return int64(len(head)) > t.maxBodySize, nil
+}
+
+// store sets res to the cache if possible.
+func (t *Transport) store(ctx context.Context, key string, req *http.Request, res *http.Response, requestTime, responseTime time.Time) (*fetched, error) {
+	defer res.Body.Close()
+	stored := false
+	if ttl, ok := t.prepareToStore(req, res, requestTime, responseTime); ok {
+		stored = t.set(ctx, key, req, res, ttl)
+	}
+	resb, err := httputil.DumpResponse(res, true)
 	if err != nil {
 		return nil, err
 	}
-	resb := maybeResb.([]byte)
-	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resb)), req)
-	return res, err
+	return &fetched{resb: resb, status: res.StatusCode, stored: stored}, nil
+}
+
+// prepareToStore adds the metadata to res, and returns the duration for which res should be stored, and false if it should not be stored.
+func (t *Transport) prepareToStore(req *http.Request, res *http.Response, requestTime, responseTime time.Time) (time.Duration, bool) {
+	if res.Header.Get("Date") == "" {
+		res.Header.Set("Date", responseTime.UTC().Format(http.TimeFormat))
+	}
+	setResponseTimes(res.Header, requestTime, responseTime)
+	res.Header.Del(headerLifetime)
+	if policy, _ := PolicyFromContext(req.Context()); policy.TTL > 0 {
+		setOverriddenLifetime(res.Header, policy.TTL)
+	}
+	recordVariedHeaders(res.Header, req)
+	if _, ok := t.cacheableStatusCodes[res.StatusCode]; !ok || !t.storable(req, res) {
+		return 0, false
+	}
+	return t.ttl(res, requestTime, responseTime)
+}
+
+// set sets res to the cache.
+// A response varying by request header fields is set to both the primary key and the secondary key of its variant.
+func (t *Transport) set(ctx context.Context, key string, req *http.Request, res *http.Response, ttl time.Duration) bool {
+	keys := []string{key}
+	if fields := varyFields(res.Header); len(fields) > 0 {
+		setVariantsSince(res.Header, t.variantsSince(ctx, key, req, res))
+		keys = append(keys, variantKey(key, fields, req))
+	}
+	for _, key := range keys {
+		if err := t.cacheEngine.Set(ctx, key, res, ttl); err != nil {
+			t.logger.ErrorContext(ctx, "through http-client-cache because failed to set to cache", slog.Any("error", err))
+			return false
+		}
+	}
+	return true
+}
+
+// variantsSince returns the time since which the secondary entries of the variants stored along with res are valid.
+// It is carried over from the primary entry. If the primary entry is missing, for example deleted by invalidation,
+// it is renewed to the time res was received, so that the secondary entries stored before are no longer selected.
+func (t *Transport) variantsSince(ctx context.Context, key string, req *http.Request, res *http.Response) time.Time {
+	if primary, ok, err := t.cacheEngine.Get(ctx, key, req); err == nil && ok {
+		primary.Body.Close()
+		if since, ok := variantsSince(primary.Header); ok {
+			return since
+		}
+	}
+	_, responseTime, _ := responseTimes(res.Header)
+	return responseTime
+}
+
+// prepareResponse prepares a response to be returned to the caller.
+// fromCache indicates that the response is served from the stored response, for which the Age header is generated.
+func (t *Transport) prepareResponse(res *http.Response, status cacheStatus, fromCache bool) *http.Response {
+	if t.emitCacheStatus {
+		if _, responseTime, ok := responseTimes(res.Header); ok && fromCache {
+			age := t.age(res).Truncate(time.Second)
+			res.Header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+			ttl := t.lifetime(res.Header, responseTime) - age
+			status.ttl = &ttl
+		}
+		res.Header.Add("Cache-Status", status.String())
+	}
+	stripMetadata(res.Header)
+	return res
+}
+
+func (t *Transport) storable(req *http.Request, res *http.Response) bool {
+	if varyAll(res.Header) || parseRequestCacheControl(req.Header).has("no-store") {
+		return false
+	}
+	if !t.respectCacheControl {
+		return true
+	}
+	return storable(req, res, t.sharedCache)
 }
@@ -3,11 +3,14 @@ package httpclientcache
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -17,6 +20,7 @@ import (
 	"github.com/Arthur1/http-client-cache/internal/testutil"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/sync/singleflight"
 )
 
 func assertTransport(t *testing.T, maybeTranport http.RoundTripper) *Transport {
@@ -43,6 +47,14 @@ func TestNewTransport(t *testing.T) {
 		testutil.NoDiff(t, defaultCacheableStatusCodes, transport.cacheableStatusCodes, nil)
 		assert.Equal(t, defaultLogger, transport.logger)
 		assert.Equal(t, defaultExpiration, transport.expiration)
+		assert.False(t, transport.respectCacheControl)
+		assert.Equal(t, defaultSharedCache, transport.sharedCache)
+		assert.Nil(t, transport.locker)
+		assert.NotNil(t, transport.group)
+		assert.NotSame(t, transport.group, assertTransport(t, NewTransport(cacheEngine)).group)
+		assert.Equal(t, defaultLockTTL, transport.lockTTL)
+		assert.Equal(t, defaultLockWait, transport.lockWait)
+		assert.Zero(t, transport.fetchTimeout)
 	})
 
 	t.Run("WithBase", func(t *testing.T) {
@@ -76,6 +88,83 @@ func TestNewTransport(t *testing.T) {
 		transport := assertTransport(t, NewTransport(nil, WithExpiration(expiration)))
 		assert.Equal(t, expiration, transport.expiration)
 	})
+
+	t.Run("WithRespectCacheControl", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithRespectCacheControl(true)))
+		assert.True(t, transport.respectCacheControl)
+	})
+
+	t.Run("WithSharedCache", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithSharedCache(false)))
+		assert.False(t, transport.sharedCache)
+	})
+
+	t.Run("WithStaleRetention", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStaleRetention(time.Hour)))
+		assert.Equal(t, time.Hour, transport.staleRetention)
+	})
+
+	t.Run("WithStaleWhileRevalidate", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStaleWhileRevalidate(time.Hour)))
+		assert.Equal(t, time.Hour, transport.staleWhileRevalidate)
+	})
+
+	t.Run("WithStaleIfError", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStaleIfError(time.Hour)))
+		assert.Equal(t, time.Hour, transport.staleIfError)
+		testutil.NoDiff(t, defaultStaleIfErrorStatuses, transport.staleIfErrorStatuses, nil)
+	})
+
+	t.Run("WithStaleIfErrorStatusCodes", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStaleIfErrorStatusCodes([]int{http.StatusTooManyRequests})))
+		testutil.NoDiff(t, map[int]struct{}{http.StatusTooManyRequests: {}}, transport.staleIfErrorStatuses, nil)
+	})
+
+	t.Run("WithCacheStatus", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithCacheStatus(true)))
+		assert.True(t, transport.emitCacheStatus)
+	})
+
+	t.Run("WithSingleflightGroup", func(t *testing.T) {
+		t.Parallel()
+		group := &singleflight.Group{}
+		transport := assertTransport(t, NewTransport(nil, WithSingleflightGroup(group)))
+		assert.Same(t, group, transport.group)
+	})
+
+	t.Run("WithFetchTimeout", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithFetchTimeout(time.Second)))
+		assert.Equal(t, time.Second, transport.fetchTimeout)
+	})
+
+	t.Run("WithStreaming", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithStreaming(true)))
+		assert.True(t, transport.streaming)
+	})
+
+	t.Run("WithMaxBodySize", func(t *testing.T) {
+		t.Parallel()
+		transport := assertTransport(t, NewTransport(nil, WithMaxBodySize(1024)))
+		assert.Equal(t, int64(1024), transport.maxBodySize)
+	})
+
+	t.Run("WithLocker", func(t *testing.T) {
+		t.Parallel()
+		locker := &mock_engine.MockLocker{}
+		transport := assertTransport(t, NewTransport(nil, WithLocker(locker), WithLockTTL(time.Minute), WithLockWait(time.Second)))
+		assert.Equal(t, locker, transport.locker)
+		assert.Equal(t, time.Minute, transport.lockTTL)
+		assert.Equal(t, time.Second, transport.lockWait)
+	})
 }
 
 func TestTransportRoundTrip(t *testing.T) {
@@ -281,4 +370,1155 @@ func TestTransportRoundTrip(t *testing.T) {
 		assert.Equal(t, "OK\n", string(resb))
 		assert.Equal(t, int64(1), counter)
 	})
+
+	t.Run("If respecting Cache-Control, expiration is computed from the response", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header http.Header
+			want   time.Duration
+		}{
+			{
+				name:   "max-age",
+				header: http.Header{"Cache-Control": {"max-age=30"}},
+				want:   30 * time.Second,
+			},
+			{
+				name:   "s-maxage",
+				header: http.Header{"Cache-Control": {"max-age=30, s-maxage=300"}},
+				want:   300 * time.Second,
+			},
+			{
+				name:   "Age is subtracted",
+				header: http.Header{"Cache-Control": {"max-age=30"}, "Age": {"10"}},
+				want:   20 * time.Second,
+			},
+			{
+				name:   "Expires",
+				header: http.Header{"Expires": {time.Now().Add(time.Hour).Format(http.TimeFormat)}},
+				want:   time.Hour,
+			},
+			{
+				name:   "fallback to WithExpiration",
+				header: http.Header{},
+				want:   5 * time.Minute,
+			},
+		}
+		for _, tt := range tests {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					for k, v := range tt.header {
+						w.Header()[k] = v
+					}
+					fmt.Fprintln(w, "OK")
+				}))
+				defer ts.Close()
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+				cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+				cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+				var ttl time.Duration
+				cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ string, _ *http.Response, expiration time.Duration) error {
+						ttl = expiration
+						return nil
+					},
+				).Times(1)
+
+				transport := NewTransport(cacheEngineMock, WithRespectCacheControl(true), WithExpiration(5*time.Minute))
+				client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "OK\n", string(resb))
+				assert.InDelta(t, tt.want, ttl, float64(time.Second))
+			})
+		}
+	})
+
+	t.Run("If respecting Cache-Control and the response is already stale, do not set to cache", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=0")
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(nil).Times(0)
+
+		transport := NewTransport(cacheEngineMock, WithRespectCacheControl(true))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+
+	t.Run("If respecting Cache-Control, whether to set to cache follows the response directives", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			cacheControl string
+			opts         []Option
+			wantSet      bool
+		}{
+			{name: "no-store", cacheControl: "no-store", wantSet: false},
+			{name: "private in shared cache", cacheControl: "private, max-age=60", wantSet: false},
+			{name: "private in private cache", cacheControl: "private, max-age=60", opts: []Option{WithSharedCache(false)}, wantSet: true},
+			{name: "public", cacheControl: "public, max-age=60", wantSet: true},
+		}
+		for _, tt := range tests {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+					fmt.Fprintln(w, "OK")
+				}))
+				defer ts.Close()
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+				cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+				cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+				times := 0
+				if tt.wantSet {
+					times = 1
+				}
+				cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(nil).Times(times)
+
+				transport := NewTransport(cacheEngineMock, append([]Option{WithRespectCacheControl(true)}, tt.opts...)...)
+				client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "OK\n", string(resb))
+			})
+		}
+	})
+
+	t.Run("If respecting Cache-Control and the cached response has no-cache, retrieve response from origin", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			w.Header().Set("Cache-Control", "no-cache")
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+		transport := NewTransport(cacheEngineMock, WithRespectCacheControl(true))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 200 OK\nCache-Control: no-cache\nContent-Length: 6\n\nstale\n"))), req)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(resMock, true, nil).Times(1)
+
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, int64(1), counter)
+	})
+
+	t.Run("If respecting Cache-Control and the cached response is stale with validators, revalidate it", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			handler    http.HandlerFunc
+			wantBody   string
+			wantHeader string
+		}{
+			{
+				name: "304 Not Modified",
+				handler: func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, `"v1"`, r.Header.Get("If-None-Match"))
+					w.Header().Set("Cache-Control", "max-age=60")
+					w.Header().Set("ETag", `"v1"`)
+					w.Header().Set("X-Updated", "1")
+					w.WriteHeader(http.StatusNotModified)
+				},
+				wantBody:   "stale\n",
+				wantHeader: "1",
+			},
+			{
+				name: "200 OK",
+				handler: func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Cache-Control", "max-age=60")
+					w.Header().Set("ETag", `"v2"`)
+					fmt.Fprintln(w, "OK")
+				},
+				wantBody:   "OK\n",
+				wantHeader: "",
+			},
+		}
+		for _, tt := range tests {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				var counter int64
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt64(&counter, 1)
+					tt.handler(w, r)
+				}))
+				defer ts.Close()
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+				cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+				cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ context.Context, _ string, res *http.Response, expiration time.Duration) error {
+						assert.Equal(t, http.StatusOK, res.StatusCode)
+						assert.InDelta(t, 60*time.Second+time.Hour, expiration, float64(time.Second))
+						return nil
+					},
+				).Times(1)
+
+				transport := NewTransport(cacheEngineMock, WithRespectCacheControl(true), WithStaleRetention(time.Hour))
+				client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				storedAt := time.Now().Add(-time.Minute)
+				resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(fmt.Sprintf(
+					"HTTP/1.1 200 OK\nCache-Control: max-age=1\nEtag: \"v1\"\nDate: %s\nContent-Length: 6\n\nstale\n",
+					storedAt.UTC().Format(http.TimeFormat),
+				)))), req)
+				setResponseTimes(resMock.Header, storedAt, storedAt)
+				cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(resMock, true, nil).Times(1)
+
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantBody, string(resb))
+				assert.Equal(t, tt.wantHeader, res.Header.Get("X-Updated"))
+				assert.Empty(t, res.Header.Get(headerResponseTime))
+				assert.Equal(t, int64(1), counter)
+			})
+		}
+	})
+
+	t.Run("If the cached response is another variant, retrieve the variant from the secondary key", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(0)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Accept-Language", "ja")
+		primaryMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(
+			"HTTP/1.1 200 OK\nVary: Accept-Language\n"+headerVariedPrefix+"Accept-Language: en\nContent-Length: 6\n\nHello\n",
+		))), req)
+		variantMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(
+			"HTTP/1.1 200 OK\nVary: Accept-Language\n"+headerVariedPrefix+"Accept-Language: ja\nContent-Length: 11\n\nKonnichiwa\n",
+		))), req)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(primaryMock, true, nil).Times(1)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), variantKey("key", []string{"Accept-Language"}, req), gomock.Any()).Return(variantMock, true, nil).Times(1)
+
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "Konnichiwa\n", string(resb))
+		assert.Empty(t, res.Header.Get(headerVariedPrefix+"Accept-Language"))
+		assert.Equal(t, int64(0), counter)
+	})
+
+	t.Run("Requests for different variants do not share the response", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Vary", "Accept-Language")
+			fmt.Fprintln(w, r.Header.Get("Accept-Language"))
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, false, nil).AnyTimes()
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), time.Minute).Return(nil).AnyTimes()
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			acceptLanguage := "en"
+			if i%2 == 0 {
+				acceptLanguage = "ja"
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				req.Header.Set("Accept-Language", acceptLanguage)
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, acceptLanguage+"\n", string(resb))
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("If the cached response is stale within stale-while-revalidate window, return it and refresh in the background", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("swr", nil).AnyTimes()
+		storedAt := time.Now().Add(-90 * time.Second)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "swr", gomock.Any()).DoAndReturn(
+			func(_ context.Context, _ string, req *http.Request) (*http.Response, bool, error) {
+				res, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 200 OK\nContent-Length: 6\n\nstale\n"))), req)
+				setResponseTimes(res.Header, storedAt, storedAt)
+				return res, true, nil
+			},
+		).AnyTimes()
+		refreshed := make(chan struct{})
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "swr", gomock.Any(), 2*time.Minute).DoAndReturn(
+			func(_ context.Context, _ string, _ *http.Response, _ time.Duration) error {
+				close(refreshed)
+				return nil
+			},
+		).Times(1)
+
+		transport := NewTransport(cacheEngineMock, WithStaleWhileRevalidate(time.Minute))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, "stale\n", string(resb))
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-refreshed:
+		case <-time.After(3 * time.Second):
+			t.Fatal("stale response was not refreshed")
+		}
+		assert.Equal(t, int64(1), atomic.LoadInt64(&counter))
+	})
+
+	t.Run("If the origin fails within stale-if-error window, return the cached response", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			storedAt time.Time
+			closed   bool
+			status   int
+			wantErr  bool
+			wantBody string
+		}{
+			{name: "error status code", storedAt: time.Now().Add(-90 * time.Second), status: http.StatusServiceUnavailable, wantBody: "stale\n"},
+			{name: "network error", storedAt: time.Now().Add(-90 * time.Second), closed: true, wantBody: "stale\n"},
+			{name: "status code not configured", storedAt: time.Now().Add(-90 * time.Second), status: http.StatusNotFound, wantBody: "NotFound\n"},
+			{name: "error status code outside the window", storedAt: time.Now().Add(-3 * time.Minute), status: http.StatusServiceUnavailable, wantBody: "ServiceUnavailable\n"},
+			{name: "network error outside the window", storedAt: time.Now().Add(-3 * time.Minute), closed: true, wantErr: true},
+		}
+		for _, tt := range tests {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					fmt.Fprintln(w, strings.ReplaceAll(http.StatusText(tt.status), " ", ""))
+				}))
+				url := ts.URL
+				if tt.closed {
+					ts.Close()
+				} else {
+					defer ts.Close()
+				}
+
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+				cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+				cacheEngineMock.EXPECT().Key(gomock.Any()).Return("sie", nil)
+				cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(0)
+
+				transport := NewTransport(cacheEngineMock, WithStaleIfError(time.Minute))
+				client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 200 OK\nContent-Length: 6\n\nstale\n"))), req)
+				setResponseTimes(resMock.Header, tt.storedAt, tt.storedAt)
+				cacheEngineMock.EXPECT().Get(gomock.Any(), "sie", gomock.Any()).Return(resMock, true, nil).Times(1)
+
+				res, err := client.Do(req)
+				if tt.wantErr {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantBody, string(resb))
+				if tt.wantBody == "stale\n" {
+					assert.Equal(t, `111 - "Revalidation Failed"`, res.Header.Get("Warning"))
+				}
+			})
+		}
+	})
+
+	t.Run("If the request has no-cache, retrieve response from origin and set to cache", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), time.Minute).Return(nil).Times(1)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Cache-Control", "no-cache")
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, int64(1), counter)
+	})
+
+	t.Run("If the request has no-store, do not set to cache", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Cache-Control", "no-store")
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, int64(1), counter)
+	})
+
+	t.Run("If the request has only-if-cached and cache miss, return 504 without accessing origin", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Cache-Control", "only-if-cached")
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+		assert.Equal(t, int64(0), counter)
+	})
+
+	t.Run("If the request has max-stale, return the stale cached response", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		transport := NewTransport(cacheEngineMock, WithStaleIfError(time.Hour))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Cache-Control", "max-stale=60")
+		resMock, _ := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 200 OK\nContent-Length: 6\n\nstale\n"))), req)
+		storedAt := time.Now().Add(-90 * time.Second)
+		setResponseTimes(resMock.Header, storedAt, storedAt)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(resMock, true, nil).Times(1)
+
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "stale\n", string(resb))
+		assert.Equal(t, int64(0), counter)
+	})
+
+	t.Run("Coalesced requests are reported as collapsed in Cache-Status", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("collapsed", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "collapsed", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "collapsed", gomock.Any(), time.Minute).Return(nil).Times(1)
+
+		transport := NewTransport(cacheEngineMock, WithCacheStatus(true))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		var (
+			wg        sync.WaitGroup
+			collapsed int64
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+				res, err := client.Do(req)
+				assert.NoError(t, err)
+				_, err = io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				if strings.HasSuffix(res.Header.Get("Cache-Status"), "; collapsed; key=\"collapsed\"") {
+					atomic.AddInt64(&collapsed, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(9), collapsed)
+	})
+
+	t.Run("If the policy bypasses the cache, retrieve response from origin without the cache", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Times(0)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		ctx := ContextWithPolicy(context.Background(), Policy{Bypass: true})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, int64(1), counter)
+	})
+
+	t.Run("If the policy refreshes the cache, retrieve response from origin and set to cache", func(t *testing.T) {
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), time.Minute).Return(nil).Times(1)
+
+		transport := NewTransport(cacheEngineMock)
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		ctx := ContextWithPolicy(context.Background(), Policy{Refresh: true})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+		assert.Equal(t, int64(1), counter)
+	})
+
+	t.Run("If the policy overrides TTL, set to cache with the TTL", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "", gomock.Any()).Return(nil, false, nil).Times(1)
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "", gomock.Any(), time.Hour).Return(nil).Times(1)
+
+		transport := NewTransport(cacheEngineMock, WithRespectCacheControl(true))
+		client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+		ctx := ContextWithPolicy(context.Background(), Policy{TTL: time.Hour})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "OK\n", string(resb))
+	})
+}
+
+func TestTransportCoalescingScope(t *testing.T) {
+	t.Parallel()
+	// originHandler returns a handler responding body after all the expected requests arrive or a timeout.
+	originHandler := func(body string, counter *int64, arrived *sync.WaitGroup) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(counter, 1)
+			arrived.Done()
+			done := make(chan struct{})
+			go func() {
+				arrived.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(200 * time.Millisecond):
+			}
+			fmt.Fprintln(w, body)
+		}
+	}
+	newCacheEngineMock := func(ctrl *gomock.Controller) *mock_engine.MockCacheEngine {
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("same", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "same", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "same", gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+		return cacheEngineMock
+	}
+	roundTripConcurrently := func(t *testing.T, url string, transports ...http.RoundTripper) []string {
+		t.Helper()
+		bodies := make([]string, len(transports))
+		var wg sync.WaitGroup
+		for i, transport := range transports {
+			wg.Add(1)
+			go func(i int, transport http.RoundTripper) {
+				defer wg.Done()
+				client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				res, err := client.Do(req)
+				if !assert.NoError(t, err) {
+					return
+				}
+				resb, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				bodies[i] = string(resb)
+			}(i, transport)
+		}
+		wg.Wait()
+		return bodies
+	}
+
+	t.Run("Transports do not coalesce requests with each other by default", func(t *testing.T) {
+		t.Parallel()
+		var (
+			counter1, counter2 int64
+			arrived            sync.WaitGroup
+		)
+		arrived.Add(2)
+		ts1 := httptest.NewServer(originHandler("origin1", &counter1, &arrived))
+		defer ts1.Close()
+		ts2 := httptest.NewServer(originHandler("origin2", &counter2, &arrived))
+		defer ts2.Close()
+
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		// both transports compute the same key, while their base transports send requests to different origins
+		transport1 := NewTransport(newCacheEngineMock(ctrl), WithBase(rewriteHostTransport{ts1.URL}))
+		transport2 := NewTransport(newCacheEngineMock(ctrl), WithBase(rewriteHostTransport{ts2.URL}))
+
+		bodies := roundTripConcurrently(t, "http://example.com", transport1, transport2)
+		assert.Equal(t, []string{"origin1\n", "origin2\n"}, bodies)
+		assert.Equal(t, int64(1), counter1)
+		assert.Equal(t, int64(1), counter2)
+	})
+
+	t.Run("Transports sharing a group coalesce requests with each other", func(t *testing.T) {
+		t.Parallel()
+		var counter int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&counter, 1)
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(w, "OK")
+		}))
+		defer ts.Close()
+
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		group := &singleflight.Group{}
+		transport1 := NewTransport(newCacheEngineMock(ctrl), WithSingleflightGroup(group))
+		transport2 := NewTransport(newCacheEngineMock(ctrl), WithSingleflightGroup(group))
+
+		bodies := roundTripConcurrently(t, ts.URL, transport1, transport2)
+		assert.Equal(t, []string{"OK\n", "OK\n"}, bodies)
+		assert.Equal(t, int64(1), counter)
+	})
+}
+
+// rewriteHostTransport sends requests to the origin at url regardless of their URL.
+type rewriteHostTransport struct {
+	url string
+}
+
+func (t rewriteHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, err := req.URL.Parse(t.url)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = u.Scheme, u.Host, u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTransportCoalescingCancellation(t *testing.T) {
+	t.Parallel()
+	// newOrigin returns an origin which signals arrival of requests and responds after release is closed.
+	newOrigin := func(t *testing.T) (url string, arrived chan struct{}, release chan struct{}) {
+		t.Helper()
+		arrived, release = make(chan struct{}, 10), make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			arrived <- struct{}{}
+			select {
+			case <-release:
+			case <-time.After(3 * time.Second):
+			}
+			fmt.Fprintln(w, "OK")
+		}))
+		t.Cleanup(ts.Close)
+		return ts.URL, arrived, release
+	}
+	roundTrip := func(ctx context.Context, transport http.RoundTripper, url string) (string, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		resb, err := io.ReadAll(res.Body)
+		return string(resb), err
+	}
+
+	t.Run("Cancellation of the request starting the fetch does not fail the others", func(t *testing.T) {
+		t.Parallel()
+		url, arrived, release := newOrigin(t)
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		stored := make(chan struct{})
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).DoAndReturn(func(ctx context.Context, _ string, _ *http.Response, _ time.Duration) error {
+			assert.NoError(t, ctx.Err())
+			close(stored)
+			return nil
+		})
+		transport := NewTransport(cacheEngineMock)
+
+		leaderCtx, cancel := context.WithCancel(context.Background())
+		leaderErr := make(chan error)
+		go func() {
+			_, err := roundTrip(leaderCtx, transport, url)
+			leaderErr <- err
+		}()
+		<-arrived
+		followerBody := make(chan string)
+		go func() {
+			body, err := roundTrip(context.Background(), transport, url)
+			assert.NoError(t, err)
+			followerBody <- body
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		assert.ErrorIs(t, <-leaderErr, context.Canceled)
+		close(release)
+
+		assert.Equal(t, "OK\n", <-followerBody)
+		<-stored
+		assert.Len(t, arrived, 0)
+	})
+
+	t.Run("Client.Timeout of the request starting the fetch does not fail the others", func(t *testing.T) {
+		t.Parallel()
+		url, arrived, release := newOrigin(t)
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).Return(nil)
+		transport := NewTransport(cacheEngineMock)
+
+		// http.Client closes Request.Cancel on timeout, since Transport is not *http.Transport
+		leaderClient := &http.Client{Timeout: 100 * time.Millisecond, Transport: transport}
+		leaderErr := make(chan error)
+		go func() {
+			_, err := leaderClient.Get(url)
+			leaderErr <- err
+		}()
+		<-arrived
+		followerBody := make(chan string)
+		go func() {
+			body, err := roundTrip(context.Background(), transport, url)
+			assert.NoError(t, err)
+			followerBody <- body
+		}()
+		assert.Error(t, <-leaderErr)
+		close(release)
+
+		assert.Equal(t, "OK\n", <-followerBody)
+		assert.Len(t, arrived, 0)
+	})
+
+	t.Run("Cancellation of the request starting the fetch does not abort storing", func(t *testing.T) {
+		t.Parallel()
+		url, arrived, release := newOrigin(t)
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil)
+		stored := make(chan struct{})
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).DoAndReturn(func(context.Context, string, *http.Response, time.Duration) error {
+			close(stored)
+			return nil
+		})
+		transport := NewTransport(cacheEngineMock)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-arrived
+			cancel()
+			close(release)
+		}()
+		_, err := roundTrip(ctx, transport, url)
+		assert.ErrorIs(t, err, context.Canceled)
+		select {
+		case <-stored:
+		case <-time.After(3 * time.Second):
+			t.Error("response is not stored")
+		}
+	})
+
+	t.Run("Each request stops waiting on its own cancellation", func(t *testing.T) {
+		t.Parallel()
+		url, arrived, release := newOrigin(t)
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).AnyTimes()
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).AnyTimes()
+		cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).Return(nil)
+		transport := NewTransport(cacheEngineMock)
+
+		leaderBody := make(chan string)
+		go func() {
+			body, err := roundTrip(context.Background(), transport, url)
+			assert.NoError(t, err)
+			leaderBody <- body
+		}()
+		<-arrived
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := roundTrip(ctx, transport, url)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		close(release)
+
+		assert.Equal(t, "OK\n", <-leaderBody)
+	})
+
+	t.Run("The shared fetch times out by WithFetchTimeout", func(t *testing.T) {
+		t.Parallel()
+		url, _, release := newOrigin(t)
+		defer close(release)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil)
+		transport := NewTransport(cacheEngineMock, WithFetchTimeout(50*time.Millisecond))
+
+		_, err := roundTrip(context.Background(), transport, url)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestTransportDetach(t *testing.T) {
+	t.Parallel()
+	withDeadline := func(t *testing.T, d time.Duration) context.Context {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	tests := []struct {
+		name         string
+		opts         []Option
+		ctx          func(t *testing.T) context.Context
+		wantDeadline time.Duration
+	}{
+		{
+			name: "Without deadline, the fetch has no timeout",
+			ctx:  func(*testing.T) context.Context { return context.Background() },
+		},
+		{
+			name:         "A short deadline is extended to defaultFetchTimeout",
+			ctx:          func(t *testing.T) context.Context { return withDeadline(t, 100*time.Millisecond) },
+			wantDeadline: defaultFetchTimeout,
+		},
+		{
+			name:         "A long deadline is kept",
+			ctx:          func(t *testing.T) context.Context { return withDeadline(t, time.Hour) },
+			wantDeadline: time.Hour,
+		},
+		{
+			name:         "WithFetchTimeout takes precedence over the deadline",
+			opts:         []Option{WithFetchTimeout(time.Second)},
+			ctx:          func(t *testing.T) context.Context { return withDeadline(t, time.Hour) },
+			wantDeadline: time.Second,
+		},
+		{
+			name: "Negative WithFetchTimeout means no timeout",
+			opts: []Option{WithFetchTimeout(-1)},
+			ctx:  func(t *testing.T) context.Context { return withDeadline(t, time.Hour) },
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			transport := assertTransport(t, NewTransport(nil, tt.opts...))
+			ctx, cancel := context.WithCancel(tt.ctx(t))
+			dctx, dcancel := transport.detach(ctx)
+			defer dcancel()
+			cancel()
+			assert.NoError(t, dctx.Err())
+
+			deadline, ok := dctx.Deadline()
+			if tt.wantDeadline == 0 {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.WithinDuration(t, time.Now().Add(tt.wantDeadline), deadline, time.Second)
+		})
+	}
+}
+
+func TestTransportWithMaxBodySize(t *testing.T) {
+	t.Parallel()
+	// newOrigin returns an origin responding body, with Content-Length if withLength.
+	newOrigin := func(t *testing.T, body string, withLength bool) (url string, requested *atomic.Int32) {
+		t.Helper()
+		requested = &atomic.Int32{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested.Add(1)
+			if withLength {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			for _, c := range body {
+				// flush each byte so that the length is unknown without Content-Length
+				fmt.Fprint(w, string(c))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		t.Cleanup(ts.Close)
+		return ts.URL, requested
+	}
+	roundTrip := func(t *testing.T, transport http.RoundTripper, url string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		res, err := transport.RoundTrip(req)
+		if !assert.NoError(t, err) {
+			return ""
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		withLength bool
+		streaming  bool
+		wantStored bool
+	}{
+		{name: "A response with Content-Length over the limit is not stored", body: "Hello, World!", withLength: true},
+		{name: "A response of unknown length over the limit is not stored", body: "Hello, World!"},
+		{name: "A response of unknown length within the limit is stored", body: "Hello", wantStored: true},
+		{name: "A response with Content-Length within the limit is stored", body: "Hello", withLength: true, wantStored: true},
+		{name: "A streamed response with Content-Length over the limit is not stored", body: "Hello, World!", withLength: true, streaming: true},
+		{name: "A streamed response of unknown length over the limit is not stored", body: "Hello, World!", streaming: true},
+		{name: "A streamed response within the limit is stored", body: "Hello", streaming: true, wantStored: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			url, _ := newOrigin(t, tt.body, tt.withLength)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+			cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil)
+			cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil)
+			if tt.wantStored {
+				cacheEngineMock.EXPECT().Set(gomock.Any(), "key", gomock.Any(), time.Minute).Return(nil)
+			}
+			transport := NewTransport(cacheEngineMock, WithMaxBodySize(8), WithStreaming(tt.streaming))
+
+			assert.Equal(t, tt.body, roundTrip(t, transport, url))
+		})
+	}
+
+	t.Run("Coalesced requests fetch the response over the limit by themselves", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		requested := &atomic.Int32{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requested.Add(1) == 1 {
+				<-release
+			}
+			fmt.Fprint(w, "Hello, World!")
+		}))
+		defer ts.Close()
+		ctrl := gomock.NewController(testutil.NewConcurrentTestReporter(t))
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).Times(2)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).Times(2)
+		transport := NewTransport(cacheEngineMock, WithMaxBodySize(8))
+
+		bodies := make(chan string, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				bodies <- roundTrip(t, transport, ts.URL)
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+
+		assert.Equal(t, "Hello, World!", <-bodies)
+		assert.Equal(t, "Hello, World!", <-bodies)
+		assert.Equal(t, int32(2), requested.Load())
+	})
+
+	t.Run("A request does not wait for the body over the limit passed to another request to be read", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("a", 100)
+		url, requested := newOrigin(t, body, true)
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		cacheEngineMock := mock_engine.NewMockCacheEngine(ctrl)
+		cacheEngineMock.EXPECT().Key(gomock.Any()).Return("key", nil).Times(2)
+		cacheEngineMock.EXPECT().Get(gomock.Any(), "key", gomock.Any()).Return(nil, false, nil).Times(2)
+		transport := NewTransport(cacheEngineMock, WithMaxBodySize(10))
+
+		req1, _ := http.NewRequest(http.MethodGet, url, nil)
+		res1, err := transport.RoundTrip(req1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer res1.Body.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		req2, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		res2, err := transport.RoundTrip(req2)
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, err := io.ReadAll(res2.Body)
+		res2.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(b))
+
+		b, err = io.ReadAll(res1.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(b))
+		assert.Equal(t, int32(2), requested.Load())
+	})
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -74,3 +75,237 @@ func TestTransportWithRedisEngine(t *testing.T) {
 	assert.Equal(t, "OK\n", string(resb4))
 	assert.Equal(t, int64(3), counter)
 }
+
+func TestTransportWithRedisEngineRevalidation(t *testing.T) {
+	t.Parallel()
+	var counter, notModifiedCounter int64
+	var clockOffset atomic.Int64
+	now := func() time.Time { return time.Now().Add(time.Duration(clockOffset.Load())) }
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&counter, 1)
+		w.Header().Set("Date", now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt64(&notModifiedCounter, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	}))
+	defer ts.Close()
+
+	rs, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rs.Addr(),
+		DB:   0,
+	})
+
+	transport := assertTransport(t, NewTransport(
+		rediscache.New(redisCli),
+		WithRespectCacheControl(true),
+		WithStaleRetention(time.Hour),
+	))
+	transport.now = now
+	client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+	// access origin
+	req1, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res1, err := client.Do(req1)
+	assert.NoError(t, err)
+	resb1, err := io.ReadAll(res1.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb1))
+	assert.Equal(t, int64(1), counter)
+
+	// fetch from cache because fresh
+	req2, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res2, err := client.Do(req2)
+	assert.NoError(t, err)
+	resb2, err := io.ReadAll(res2.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb2))
+	assert.Equal(t, int64(1), counter)
+
+	// revalidate because stale, and reuse the stored body
+	clockOffset.Store(int64(time.Minute))
+	req3, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res3, err := client.Do(req3)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res3.StatusCode)
+	resb3, err := io.ReadAll(res3.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb3))
+	assert.Equal(t, int64(2), counter)
+	assert.Equal(t, int64(1), notModifiedCounter)
+
+	// fetch from cache because freshened by 304 Not Modified
+	req4, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res4, err := client.Do(req4)
+	assert.NoError(t, err)
+	resb4, err := io.ReadAll(res4.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb4))
+	assert.Equal(t, int64(2), counter)
+}
+
+func TestTransportWithRedisEngineVary(t *testing.T) {
+	t.Parallel()
+	var counter int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&counter, 1)
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintln(w, r.Header.Get("Accept-Language"))
+	}))
+	defer ts.Close()
+
+	rs, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rs.Addr(),
+		DB:   0,
+	})
+
+	transport := NewTransport(rediscache.New(redisCli))
+	client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+	do := func(acceptLanguage string) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		resb, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return string(resb)
+	}
+
+	// access origin
+	assert.Equal(t, "en\n", do("en"))
+	assert.Equal(t, int64(1), counter)
+
+	// access origin because another variant
+	assert.Equal(t, "ja\n", do("ja"))
+	assert.Equal(t, int64(2), counter)
+
+	// fetch each variant from cache
+	assert.Equal(t, "en\n", do("en"))
+	assert.Equal(t, "ja\n", do("ja"))
+	assert.Equal(t, int64(2), counter)
+}
+
+func TestTransportWithRedisEngineInvalidation(t *testing.T) {
+	t.Parallel()
+	var counter int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&counter, 1)
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	}))
+	defer ts.Close()
+
+	rs, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rs.Addr(),
+		DB:   0,
+	})
+
+	transport := NewTransport(rediscache.New(redisCli))
+	client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+	// access origin
+	req1, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res1, err := client.Do(req1)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(res1.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+
+	// fetch from cache
+	req2, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res2, err := client.Do(req2)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(res2.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+
+	// invalidate by unsafe method
+	req3, _ := http.NewRequest(http.MethodPut, ts.URL, strings.NewReader("NG"))
+	res3, err := client.Do(req3)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res3.StatusCode)
+	assert.Equal(t, int64(2), counter)
+
+	// access origin because invalidated
+	req4, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res4, err := client.Do(req4)
+	assert.NoError(t, err)
+	resb4, err := io.ReadAll(res4.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", string(resb4))
+	assert.Equal(t, int64(3), counter)
+}
+
+func TestTransportWithRedisEngineCacheStatus(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Age", "10")
+		fmt.Fprintln(w, "OK")
+	}))
+	defer ts.Close()
+
+	rs, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: rs.Addr(),
+		DB:   0,
+	})
+
+	cacheEngine := rediscache.New(redisCli)
+	transport := assertTransport(t, NewTransport(cacheEngine, WithRespectCacheControl(true), WithCacheStatus(true)))
+	client := &http.Client{Timeout: 3 * time.Second, Transport: transport}
+
+	// access origin
+	req1, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	key, err := cacheEngine.Key(req1)
+	assert.NoError(t, err)
+	res1, err := client.Do(req1)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(res1.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`http-client-cache; fwd=uri-miss; fwd-status=200; stored; key="%s"`, key), res1.Header.Get("Cache-Status"))
+	assert.Equal(t, "10", res1.Header.Get("Age"))
+
+	// fetch from cache
+	now := time.Now().Add(20 * time.Second)
+	transport.now = func() time.Time { return now }
+	req2, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	res2, err := client.Do(req2)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(res2.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`http-client-cache; hit; ttl=30; key="%s"`, key), res2.Header.Get("Cache-Status"))
+	assert.Equal(t, "30", res2.Header.Get("Age"))
+
+	// bypass cache
+	req3, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req3.Header.Set("Cache-Control", "no-cache")
+	res3, err := client.Do(req3)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(res3.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`http-client-cache; fwd=request; fwd-status=200; stored; key="%s"`, key), res3.Header.Get("Cache-Status"))
+}
@@ -0,0 +1,57 @@
+package httpclientcache
+
+import (
+	"context"
+	"net/http"
+)
+
+func hasValidators(h http.Header) bool {
+	return h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// conditionalRequest returns a copy of req to validate the stored response (RFC 9111 Section 4.3.1).
+// Preconditions already set by the caller are kept.
+func conditionalRequest(ctx context.Context, req *http.Request, stored *http.Response) *http.Request {
+	condReq := req.Clone(ctx)
+	if etag := stored.Header.Get("ETag"); etag != "" && condReq.Header.Get("If-None-Match") == "" {
+		condReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := stored.Header.Get("Last-Modified"); lastModified != "" && condReq.Header.Get("If-Modified-Since") == "" {
+		condReq.Header.Set("If-Modified-Since", lastModified)
+	}
+	return condReq
+}
+
+// notModifiedMatches reports whether a 304 Not Modified response is about the stored response (RFC 9111 Section 4.3.4).
+func notModifiedMatches(notModified, stored *http.Response) bool {
+	if etag := notModified.Header.Get("ETag"); etag != "" {
+		return etag == stored.Header.Get("ETag")
+	}
+	if lastModified := notModified.Header.Get("Last-Modified"); lastModified != "" {
+		return lastModified == stored.Header.Get("Last-Modified")
+	}
+	return true
+}
+
+// header fields which are not updated by a 304 Not Modified response (RFC 9111 Section 3.2)
+var notUpdatedHeaders = map[string]struct{}{
+	"Connection":        {},
+	"Content-Encoding":  {},
+	"Content-Length":    {},
+	"Keep-Alive":        {},
+	"Proxy-Connection":  {},
+	"Te":                {},
+	"Trailer":           {},
+	"Transfer-Encoding": {},
+	"Upgrade":           {},
+}
+
+// updateStoredHeader updates the header fields of a stored response with those of a 304 Not Modified response.
+func updateStoredHeader(stored, notModified http.Header) {
+	for name, values := range notModified {
+		if _, ok := notUpdatedHeaders[name]; ok {
+			continue
+		}
+		stored[name] = values
+	}
+}
@@ -0,0 +1,71 @@
+package httpclientcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Arthur1/http-client-cache/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionalRequest(t *testing.T) {
+	t.Parallel()
+	t.Run("Validators of the stored response are sent", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		stored := &http.Response{Header: http.Header{
+			"Etag":          {`"v1"`},
+			"Last-Modified": {"Mon, 01 Jul 2024 00:00:00 GMT"},
+		}}
+		got := conditionalRequest(context.Background(), req, stored)
+		assert.Equal(t, `"v1"`, got.Header.Get("If-None-Match"))
+		assert.Equal(t, "Mon, 01 Jul 2024 00:00:00 GMT", got.Header.Get("If-Modified-Since"))
+		assert.Empty(t, req.Header.Get("If-None-Match"), "original request is not modified")
+	})
+
+	t.Run("Preconditions set by the caller are kept", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("If-None-Match", `"v0"`)
+		stored := &http.Response{Header: http.Header{"Etag": {`"v1"`}}}
+		got := conditionalRequest(context.Background(), req, stored)
+		assert.Equal(t, `"v0"`, got.Header.Get("If-None-Match"))
+	})
+}
+
+func TestNotModifiedMatches(t *testing.T) {
+	t.Parallel()
+	stored := &http.Response{Header: http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Mon, 01 Jul 2024 00:00:00 GMT"},
+	}}
+	assert.True(t, notModifiedMatches(&http.Response{Header: http.Header{"Etag": {`"v1"`}}}, stored))
+	assert.False(t, notModifiedMatches(&http.Response{Header: http.Header{"Etag": {`"v2"`}}}, stored))
+	assert.True(t, notModifiedMatches(&http.Response{Header: http.Header{"Last-Modified": {"Mon, 01 Jul 2024 00:00:00 GMT"}}}, stored))
+	assert.False(t, notModifiedMatches(&http.Response{Header: http.Header{"Last-Modified": {"Tue, 02 Jul 2024 00:00:00 GMT"}}}, stored))
+	assert.True(t, notModifiedMatches(&http.Response{Header: http.Header{}}, stored))
+}
+
+func TestUpdateStoredHeader(t *testing.T) {
+	t.Parallel()
+	stored := http.Header{
+		"Cache-Control":  {"max-age=60"},
+		"Content-Length": {"3"},
+		"Content-Type":   {"text/plain"},
+		"Date":           {"Mon, 01 Jul 2024 00:00:00 GMT"},
+	}
+	notModified := http.Header{
+		"Cache-Control":  {"max-age=120"},
+		"Content-Length": {"0"},
+		"Date":           {"Tue, 02 Jul 2024 00:00:00 GMT"},
+	}
+	updateStoredHeader(stored, notModified)
+	want := http.Header{
+		"Cache-Control":  {"max-age=120"},
+		"Content-Length": {"3"},
+		"Content-Type":   {"text/plain"},
+		"Date":           {"Tue, 02 Jul 2024 00:00:00 GMT"},
+	}
+	testutil.NoDiff(t, want, stored, nil)
+}
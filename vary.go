@@ -0,0 +1,98 @@
+package httpclientcache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// varyFields returns the canonicalized request header field names listed in the Vary header.
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, line := range h.Values("Vary") {
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			field = http.CanonicalHeaderKey(field)
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// varyAll reports whether the response is varied by "*", which never matches any request (RFC 9111 Section 4.1).
+func varyAll(h http.Header) bool {
+	return slices.Contains(varyFields(h), "*")
+}
+
+func normalizedFieldValue(h http.Header, field string) string {
+	var values []string
+	for _, v := range h.Values(field) {
+		values = append(values, strings.TrimSpace(v))
+	}
+	return strings.Join(values, ", ")
+}
+
+// recordVariedHeaders adds the values of the request header fields nominated by Vary to the response header.
+func recordVariedHeaders(resHeader http.Header, req *http.Request) {
+	for _, field := range varyFields(resHeader) {
+		resHeader.Set(headerVariedPrefix+field, normalizedFieldValue(req.Header, field))
+	}
+}
+
+// variantMatches reports whether the stored response can be selected for req (RFC 9111 Section 4.1).
+func variantMatches(stored http.Header, req *http.Request) bool {
+	for _, field := range varyFields(stored) {
+		if field == "*" {
+			return false
+		}
+		if stored.Get(headerVariedPrefix+field) != normalizedFieldValue(req.Header, field) {
+			return false
+		}
+	}
+	return true
+}
+
+// variantKey returns the secondary cache key of the variant selected by fields for req.
+func variantKey(key string, fields []string, req *http.Request) string {
+	h := fnv.New64a()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+		h.Write([]byte(normalizedFieldValue(req.Header, field)))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s_vary_%x", key, h.Sum64())
+}
+
+func setVariantsSince(h http.Header, since time.Time) {
+	h.Set(headerVariantsSince, since.UTC().Format(time.RFC3339Nano))
+}
+
+// variantsSince returns the time since which the secondary entries of the variants stored along with the primary entry are valid.
+func variantsSince(h http.Header) (time.Time, bool) {
+	since, err := time.Parse(time.RFC3339Nano, h.Get(headerVariantsSince))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+// variantCurrent reports whether the secondary entry was stored after the variants of the primary entry were last invalidated.
+// Secondary entries are accepted if the primary entry does not record the time, since they were stored before it was recorded.
+func variantCurrent(primary, secondary http.Header) bool {
+	since, ok := variantsSince(primary)
+	if !ok {
+		return true
+	}
+	_, responseTime, ok := responseTimes(secondary)
+	return ok && !responseTime.Before(since)
+}
@@ -0,0 +1,93 @@
+package httpclientcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaryFields(t *testing.T) {
+	t.Parallel()
+	h := http.Header{}
+	h.Add("Vary", "accept-language, Accept-Encoding")
+	h.Add("Vary", "Accept-Language")
+	assert.Equal(t, []string{"Accept-Encoding", "Accept-Language"}, varyFields(h))
+	assert.Empty(t, varyFields(http.Header{}))
+	assert.False(t, varyAll(h))
+	assert.True(t, varyAll(http.Header{"Vary": {"Accept, *"}}))
+}
+
+func TestVariantMatches(t *testing.T) {
+	t.Parallel()
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("Accept-Language", "en")
+	stored := http.Header{"Vary": {"Accept-Language, Accept-Encoding"}}
+	recordVariedHeaders(stored, req)
+
+	t.Run("Same values", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("Accept-Language", " en")
+		assert.True(t, variantMatches(stored, req))
+	})
+
+	t.Run("Different values", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("Accept-Language", "ja")
+		assert.False(t, variantMatches(stored, req))
+	})
+
+	t.Run("Field absent in the request", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		assert.False(t, variantMatches(stored, req))
+	})
+
+	t.Run("Not varied", func(t *testing.T) {
+		t.Parallel()
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		assert.True(t, variantMatches(http.Header{}, req))
+	})
+
+	t.Run("Varied by *", func(t *testing.T) {
+		t.Parallel()
+		assert.False(t, variantMatches(http.Header{"Vary": {"*"}}, req))
+	})
+}
+
+func TestVariantKey(t *testing.T) {
+	t.Parallel()
+	fields := []string{"Accept-Language"}
+	req1, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req1.Header.Set("Accept-Language", "en")
+	req2, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req2.Header.Set("Accept-Language", "en")
+	req2.Header.Set("Accept-Encoding", "gzip")
+	req3, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req3.Header.Set("Accept-Language", "ja")
+
+	assert.Equal(t, variantKey("key", fields, req1), variantKey("key", fields, req2))
+	assert.NotEqual(t, variantKey("key", fields, req1), variantKey("key", fields, req3))
+	assert.NotEqual(t, variantKey("key1", fields, req1), variantKey("key2", fields, req1))
+}
+
+func TestVariantCurrent(t *testing.T) {
+	t.Parallel()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	primary := http.Header{}
+	setVariantsSince(primary, since)
+	secondary := func(storedAt time.Time) http.Header {
+		h := http.Header{}
+		setResponseTimes(h, storedAt, storedAt)
+		return h
+	}
+
+	assert.True(t, variantCurrent(primary, secondary(since)))
+	assert.True(t, variantCurrent(primary, secondary(since.Add(time.Second))))
+	assert.False(t, variantCurrent(primary, secondary(since.Add(-time.Second))))
+	assert.False(t, variantCurrent(primary, http.Header{}))
+	assert.True(t, variantCurrent(http.Header{}, secondary(since.Add(-time.Second))))
+}